@@ -3,16 +3,19 @@ module q2git
 go 1.24.0
 
 require (
+	github.com/ProtonMail/go-crypto v1.3.0
 	github.com/itchyny/gojq v0.12.18
 	go.bytecodealliance.org v0.5.0
 	go.bytecodealliance.org/cm v0.1.0
 	go.wasmcloud.dev/component v0.0.6
 	go.wasmcloud.dev/wadge v0.7.0
+	golang.org/x/crypto v0.43.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/bytecodealliance/wasm-tools-go v0.3.0 // indirect
+	github.com/cloudflare/circl v1.6.0 // indirect
 	github.com/coreos/go-semver v0.3.1 // indirect
 	github.com/docker/libtrust v0.0.0-20160708172513-aabc10ec26b7 // indirect
 	github.com/itchyny/timefmt-go v0.1.7 // indirect
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const azureAPIVersion = "7.1"
+
+// azureCommitter writes to an Azure DevOps Repos repository through the
+// pushes API, which creates one commit from a list of changes and moves the
+// branch only if it still points at the expected commit.
+type azureCommitter struct {
+	cfg   *DestinationConfig
+	items map[string]*azureItem
+}
+
+type azureItem struct {
+	ObjectID      string `json:"objectId"`
+	GitObjectType string `json:"gitObjectType"`
+}
+
+func newAzureCommitter(cfg *DestinationConfig) *azureCommitter {
+	return &azureCommitter{cfg: cfg, items: map[string]*azureItem{}}
+}
+
+// repoURL addresses the repository as {owner}/_apis/git/repositories/{repo},
+// where owner is "organization/project".
+func (a *azureCommitter) repoURL() string {
+	return fmt.Sprintf("%s/%s/_apis/git/repositories/%s", a.cfg.APIURL, strings.Trim(a.cfg.Owner, "/"), url.PathEscape(a.cfg.Repo))
+}
+
+func (a *azureCommitter) Head() (string, error) {
+	url := fmt.Sprintf("%s/refs?filter=%s&api-version=%s",
+		a.repoURL(), url.QueryEscape("heads/"+a.cfg.Branch), azureAPIVersion)
+
+	var refData struct {
+		Value []struct {
+			Name     string `json:"name"`
+			ObjectID string `json:"objectId"`
+		} `json:"value"`
+	}
+
+	if err := jsonAPIRequest("GET", url, azureHeaders(a.cfg.Token), nil, &refData); err != nil {
+		return "", fmt.Errorf("failed to get branch ref: %w", err)
+	}
+
+	// The filter is a prefix match, so pick the exact ref.
+	for _, ref := range refData.Value {
+		if ref.Name == "refs/heads/"+a.cfg.Branch {
+			return ref.ObjectID, nil
+		}
+	}
+	return "", fmt.Errorf("failed to get branch ref: '%s' not found", a.cfg.Branch)
+}
+
+// Lookup reports every existing file as regular, as the items API does not
+// expose file modes.
+func (a *azureCommitter) Lookup(ref, path string) (*fileState, error) {
+	item, err := a.getItem(ref, path)
+	if err != nil || item == nil {
+		return nil, err
+	}
+	if item.GitObjectType != "blob" {
+		return nil, fmt.Errorf("path '%s' exists as a %s", path, item.GitObjectType)
+	}
+	return &fileState{Mode: FileModeRegular, SHA: item.ObjectID}, nil
+}
+
+func (a *azureCommitter) ReadFile(ref, path string) ([]byte, error) {
+	url := fmt.Sprintf("%s&$format=octetStream", a.itemURL(ref, path))
+	content, _, err := apiRequest("GET", url, azureHeaders(a.cfg.Token), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file: %w", err)
+	}
+	return content, nil
+}
+
+// Commit pushes all changes as one commit. The ref update carries base as the
+// old object ID, so Azure DevOps rejects the push if the branch moved.
+func (a *azureCommitter) Commit(base string, changes []stagedChange) (string, error) {
+	if len(changes) == 0 {
+		return "", fmt.Errorf("azure devops does not support empty commits")
+	}
+
+	items := make([]map[string]interface{}, 0, len(changes))
+	for _, change := range changes {
+		path := "/" + strings.Trim(change.Path, "/")
+		item := map[string]interface{}{"path": path}
+
+		if change.Delete {
+			items = append(items, map[string]interface{}{"changeType": "delete", "item": item})
+			continue
+		}
+		if fileMode(change.FileChange) != FileModeRegular {
+			return "", fmt.Errorf("path '%s': azure devops only supports regular files", path)
+		}
+
+		changeType := "add"
+		if change.Current != nil {
+			changeType = "edit"
+		}
+		items = append(items, map[string]interface{}{
+			"changeType": changeType,
+			"item":       item,
+			"newContent": map[string]string{
+				"content":     base64.StdEncoding.EncodeToString(change.Content),
+				"contentType": "base64encoded",
+			},
+		})
+	}
+
+	commit := map[string]interface{}{"comment": commitMessage(a.cfg), "changes": items}
+	author, committer, err := commitIdentities(a.cfg, time.Now())
+	if err != nil {
+		return "", err
+	}
+	if author != nil {
+		commit["author"] = author.apiFields()
+		commit["committer"] = committer.apiFields()
+	}
+
+	payload := map[string]interface{}{
+		"refUpdates": []map[string]string{
+			{"name": "refs/heads/" + a.cfg.Branch, "oldObjectId": base},
+		},
+		"commits": []map[string]interface{}{commit},
+	}
+
+	var pushData struct {
+		Commits []struct {
+			CommitID string `json:"commitId"`
+		} `json:"commits"`
+	}
+
+	url := fmt.Sprintf("%s/pushes?api-version=%s", a.repoURL(), azureAPIVersion)
+	if err := jsonAPIRequest("POST", url, azureHeaders(a.cfg.Token), payload, &pushData); err != nil {
+		// TF401028: the reference has already been updated by another client.
+		var apiErr *apiError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict {
+			return "", fmt.Errorf("%w: %s", errBranchMoved, apiErr.Body)
+		}
+		return "", fmt.Errorf("failed to push commit: %w", err)
+	}
+
+	if len(pushData.Commits) == 0 {
+		return "", fmt.Errorf("push accepted but no commit returned")
+	}
+	return pushData.Commits[0].CommitID, nil
+}
+
+func (a *azureCommitter) CommitURL(sha string) string {
+	return fmt.Sprintf("%s/%s/_git/%s/commit/%s", strings.TrimRight(a.cfg.APIURL, "/"), strings.Trim(a.cfg.Owner, "/"), a.cfg.Repo, sha)
+}
+
+func (a *azureCommitter) itemURL(ref, path string) string {
+	return fmt.Sprintf("%s/items?path=%s&versionDescriptor.version=%s&versionDescriptor.versionType=commit&api-version=%s",
+		a.repoURL(), url.QueryEscape("/"+strings.Trim(path, "/")), url.QueryEscape(ref), azureAPIVersion)
+}
+
+// getItem fetches the metadata of path at ref, returning nil if it does not
+// exist.
+func (a *azureCommitter) getItem(ref, path string) (*azureItem, error) {
+	key := ref + ":" + path
+	if item, ok := a.items[key]; ok {
+		return item, nil
+	}
+
+	var item azureItem
+	if err := jsonAPIRequest("GET", a.itemURL(ref, path), azureHeaders(a.cfg.Token), nil, &item); err != nil {
+		if isNotFound(err) {
+			a.items[key] = nil
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get item: %w", err)
+	}
+
+	a.items[key] = &item
+	return &item, nil
+}
+
+// azureHeaders authenticates with a personal access token as the password of
+// an empty basic-auth user.
+func azureHeaders(token string) map[string]string {
+	return map[string]string{
+		"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte(":"+token)),
+		"Accept":        "application/json",
+	}
+}
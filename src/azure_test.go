@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.wasmcloud.dev/wadge"
+)
+
+// fakeAzure is an in-memory stand-in for the Azure DevOps refs, items and
+// pushes APIs of repository "org/project/repo". It only tracks the branch
+// head.
+type fakeAzure struct {
+	fakeHost
+}
+
+func newFakeAzure(files map[string]string) *fakeAzure {
+	f := &fakeAzure{}
+	f.init(files)
+	return f
+}
+
+func (f *fakeAzure) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte(":token")) {
+		http.Error(w, `{"message":"TF400813: not authorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	const prefix = "/org/project/_apis/git/repositories/repo/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	query := r.URL.Query()
+
+	switch path := strings.TrimPrefix(r.URL.Path, prefix); {
+	case r.Method == http.MethodGet && path == "refs":
+		// The filter is a prefix match, so main-old is listed too.
+		writeFakeJSON(w, map[string]interface{}{"value": []map[string]string{
+			{"name": "refs/heads/main-old", "objectId": strings.Repeat("0", 40)},
+			{"name": "refs/heads/main", "objectId": f.head},
+		}})
+
+	case r.Method == http.MethodGet && path == "items":
+		name := strings.TrimPrefix(query.Get("path"), "/")
+		content, ok := f.files[name]
+		if !ok || query.Get("versionDescriptor.version") != f.head {
+			http.Error(w, `{"message":"TF401174: The item could not be found"}`, http.StatusNotFound)
+			return
+		}
+		if query.Get("$format") == "octetStream" {
+			_, _ = io.WriteString(w, content)
+			return
+		}
+		writeFakeJSON(w, azureItem{ObjectID: gitBlobSHA([]byte(content)), GitObjectType: "blob"})
+
+	case r.Method == http.MethodPost && path == "pushes":
+		f.runBeforeCommit()
+		var body struct {
+			RefUpdates []struct {
+				Name        string `json:"name"`
+				OldObjectID string `json:"oldObjectId"`
+			} `json:"refUpdates"`
+			Commits []struct {
+				Comment string `json:"comment"`
+				Changes []struct {
+					ChangeType string            `json:"changeType"`
+					Item       map[string]string `json:"item"`
+					NewContent map[string]string `json:"newContent"`
+				} `json:"changes"`
+			} `json:"commits"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body.RefUpdates[0].Name != "refs/heads/main" || body.RefUpdates[0].OldObjectID != f.head {
+			http.Error(w, `{"message":"TF401028: The reference 'refs/heads/main' has already been updated by another client"}`, http.StatusConflict)
+			return
+		}
+
+		files := map[string]string{}
+		for name, content := range f.files {
+			files[name] = content
+		}
+		for _, change := range body.Commits[0].Changes {
+			name := strings.TrimPrefix(change.Item["path"], "/")
+			if _, exists := files[name]; exists != (change.ChangeType != "add") {
+				http.Error(w, `{"message":"TF401174: cannot `+change.ChangeType+` `+name+`"}`, http.StatusBadRequest)
+				return
+			}
+			if change.ChangeType == "delete" {
+				delete(files, name)
+				continue
+			}
+			content, _ := base64.StdEncoding.DecodeString(change.NewContent["content"])
+			files[name] = string(content)
+		}
+		f.files = files
+		f.head = f.nextCommit()
+		w.WriteHeader(http.StatusCreated)
+		writeFakeJSON(w, map[string]interface{}{"commits": []map[string]string{{"commitId": f.head}}})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestAzureDestination(url string) *DestinationConfig {
+	return &DestinationConfig{
+		Type:          DestinationAzureDevOps,
+		APIURL:        url,
+		Owner:         "org/project",
+		Repo:          "repo",
+		Branch:        "main",
+		CommitMessage: "update",
+		Token:         "token",
+	}
+}
+
+func TestAzureCommitterEscapesItemPaths(t *testing.T) {
+	wadge.RunTest(t, func() {
+		// Unescaped, "&" and "#" would cut the path query parameter short and
+		// the append would not find the existing file.
+		fake := newFakeAzure(map[string]string{"reports/power&usage #1.csv": "a\n"})
+		server := httptest.NewServer(fake)
+		defer server.Close()
+
+		_, err := CommitToGit(newAzureCommitter(newTestAzureDestination(server.URL)), &SettingsConfig{}, []FileChange{
+			{Path: "reports/power&usage #1.csv", Content: []byte("b\n"), WriteMode: WriteModeAppend},
+		})
+		if err != nil {
+			t.Fatalf("CommitToGit failed: %s", err)
+		}
+		if got, _ := fake.file("reports/power&usage #1.csv"); got != "a\nb\n" {
+			t.Fatalf("unexpected content: %q", got)
+		}
+	})
+}
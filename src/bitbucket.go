@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// bitbucketCommitter writes to a Bitbucket Cloud repository through the /src
+// form-post API, which creates one commit from any number of file fields.
+// Bitbucket does not expose blob SHAs, so they are computed from the content.
+type bitbucketCommitter struct {
+	cfg   *DestinationConfig
+	files map[string]*bitbucketFile
+}
+
+type bitbucketFile struct {
+	Attributes []string
+	Content    []byte
+}
+
+func newBitbucketCommitter(cfg *DestinationConfig) *bitbucketCommitter {
+	return &bitbucketCommitter{cfg: cfg, files: map[string]*bitbucketFile{}}
+}
+
+func (b *bitbucketCommitter) repoURL() string {
+	return fmt.Sprintf("%s/repositories/%s/%s", b.cfg.APIURL, url.PathEscape(b.cfg.Owner), url.PathEscape(b.cfg.Repo))
+}
+
+func (b *bitbucketCommitter) Head() (string, error) {
+	url := fmt.Sprintf("%s/refs/branches/%s", b.repoURL(), url.PathEscape(b.cfg.Branch))
+
+	var branchData struct {
+		Target struct {
+			Hash string `json:"hash"`
+		} `json:"target"`
+	}
+
+	if err := jsonAPIRequest("GET", url, bitbucketHeaders(b.cfg.Token), nil, &branchData); err != nil {
+		return "", fmt.Errorf("failed to get branch: %w", err)
+	}
+
+	return branchData.Target.Hash, nil
+}
+
+func (b *bitbucketCommitter) Lookup(ref, path string) (*fileState, error) {
+	file, err := b.getFile(ref, path)
+	if err != nil || file == nil {
+		return nil, err
+	}
+
+	mode := FileModeRegular
+	for _, attr := range file.Attributes {
+		switch attr {
+		case "executable":
+			mode = FileModeExecutable
+		case "link":
+			mode = FileModeSymlink
+		}
+	}
+	return &fileState{Mode: mode, SHA: gitBlobSHA(file.Content)}, nil
+}
+
+func (b *bitbucketCommitter) ReadFile(ref, path string) ([]byte, error) {
+	file, err := b.getFile(ref, path)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, fmt.Errorf("file '%s' not found at %s", path, ref)
+	}
+	return file.Content, nil
+}
+
+// Commit posts every file as a form field named after its path and lists
+// deletions under "files". Passing base as the only parent makes Bitbucket
+// answer 409 if the branch tip moved in the meantime.
+func (b *bitbucketCommitter) Commit(base string, changes []stagedChange) (string, error) {
+	if len(changes) == 0 {
+		return "", fmt.Errorf("bitbucket does not support empty commits")
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	_ = form.WriteField("message", commitMessage(b.cfg))
+	_ = form.WriteField("branch", b.cfg.Branch)
+	_ = form.WriteField("parents", base)
+	if b.cfg.Author.Name != "" {
+		_ = form.WriteField("author", fmt.Sprintf("%s <%s>", b.cfg.Author.Name, b.cfg.Author.Email))
+	}
+
+	for _, change := range changes {
+		path := "/" + strings.Trim(change.Path, "/")
+		if change.Delete {
+			_ = form.WriteField("files", path)
+			continue
+		}
+		if fileMode(change.FileChange) != FileModeRegular {
+			return "", fmt.Errorf("path '%s': bitbucket only supports regular files", path)
+		}
+
+		part, err := form.CreateFormFile(path, pathBase(path))
+		if err != nil {
+			return "", err
+		}
+		if _, err := part.Write(change.Content); err != nil {
+			return "", err
+		}
+	}
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+
+	headers := withHeader(bitbucketHeaders(b.cfg.Token), "Content-Type", form.FormDataContentType())
+	_, respHeaders, err := apiRequest("POST", b.repoURL()+"/src", headers, &body)
+	if err != nil {
+		var apiErr *apiError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict {
+			return "", fmt.Errorf("%w: %s", errBranchMoved, apiErr.Body)
+		}
+		return "", fmt.Errorf("failed to create commit: %w", err)
+	}
+
+	// The new commit is only reported through the Location header.
+	location := respHeaders.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("commit created but no Location header returned")
+	}
+	return pathBase(location), nil
+}
+
+func (b *bitbucketCommitter) CommitURL(sha string) string {
+	web := strings.Replace(webURL(b.cfg.APIURL, "/2.0"), "://api.", "://", 1)
+	return fmt.Sprintf("%s/%s/%s/commits/%s", web, b.cfg.Owner, b.cfg.Repo, sha)
+}
+
+// getFile fetches metadata and content of path at ref, or nil if absent.
+func (b *bitbucketCommitter) getFile(ref, filePath string) (*bitbucketFile, error) {
+	key := ref + ":" + filePath
+	if file, ok := b.files[key]; ok {
+		return file, nil
+	}
+
+	srcURL := fmt.Sprintf("%s/src/%s/%s", b.repoURL(), url.PathEscape(ref), escapePathSegments(filePath))
+
+	var meta struct {
+		Type       string   `json:"type"`
+		Attributes []string `json:"attributes"`
+	}
+	if err := jsonAPIRequest("GET", srcURL+"?format=meta", bitbucketHeaders(b.cfg.Token), nil, &meta); err != nil {
+		if isNotFound(err) {
+			b.files[key] = nil
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get file metadata: %w", err)
+	}
+	if meta.Type != "commit_file" {
+		return nil, fmt.Errorf("path '%s' exists as a %s", filePath, meta.Type)
+	}
+
+	content, _, err := apiRequest("GET", srcURL, bitbucketHeaders(b.cfg.Token), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file: %w", err)
+	}
+
+	file := &bitbucketFile{Attributes: meta.Attributes, Content: content}
+	b.files[key] = file
+	return file, nil
+}
+
+func bitbucketHeaders(token string) map[string]string {
+	return map[string]string{
+		"Authorization": "Bearer " + token,
+		"Accept":        "application/json",
+	}
+}
+
+// bitbucketServerCommitter writes to Bitbucket Server / Data Center. Its REST
+// API only edits one file per commit, so a run may change at most one file
+// to keep the write atomic.
+type bitbucketServerCommitter struct {
+	cfg   *DestinationConfig
+	files map[string][]byte
+}
+
+func newBitbucketServerCommitter(cfg *DestinationConfig) *bitbucketServerCommitter {
+	return &bitbucketServerCommitter{cfg: cfg, files: map[string][]byte{}}
+}
+
+// repoURL addresses the repository as projects/{owner}/repos/{repo}, where
+// owner is the project key.
+func (b *bitbucketServerCommitter) repoURL() string {
+	return fmt.Sprintf("%s/projects/%s/repos/%s", b.cfg.APIURL, url.PathEscape(b.cfg.Owner), url.PathEscape(b.cfg.Repo))
+}
+
+// Head looks the branch up by name. filterText matches substrings, so the
+// results are paged through for the branch whose ref is an exact match.
+func (b *bitbucketServerCommitter) Head() (string, error) {
+	ref := "refs/heads/" + b.cfg.Branch
+	for start := 0; ; {
+		url := fmt.Sprintf("%s/branches?filterText=%s&start=%d", b.repoURL(), url.QueryEscape(b.cfg.Branch), start)
+
+		var branchData struct {
+			Values []struct {
+				ID           string `json:"id"`
+				LatestCommit string `json:"latestCommit"`
+			} `json:"values"`
+			IsLastPage    bool `json:"isLastPage"`
+			NextPageStart int  `json:"nextPageStart"`
+		}
+
+		if err := jsonAPIRequest("GET", url, bitbucketHeaders(b.cfg.Token), nil, &branchData); err != nil {
+			return "", fmt.Errorf("failed to get branch: %w", err)
+		}
+
+		for _, branch := range branchData.Values {
+			if branch.ID == ref {
+				return branch.LatestCommit, nil
+			}
+		}
+		if branchData.IsLastPage || branchData.NextPageStart <= start {
+			return "", fmt.Errorf("failed to get branch: '%s' not found", b.cfg.Branch)
+		}
+		start = branchData.NextPageStart
+	}
+}
+
+// Lookup reports every existing file as regular, as the raw endpoint does
+// not expose file modes.
+func (b *bitbucketServerCommitter) Lookup(ref, path string) (*fileState, error) {
+	content, err := b.getFile(ref, path)
+	if err != nil || content == nil {
+		return nil, err
+	}
+	return &fileState{Mode: FileModeRegular, SHA: gitBlobSHA(content)}, nil
+}
+
+func (b *bitbucketServerCommitter) ReadFile(ref, path string) ([]byte, error) {
+	content, err := b.getFile(ref, path)
+	if err != nil {
+		return nil, err
+	}
+	if content == nil {
+		return nil, fmt.Errorf("file '%s' not found at %s", path, ref)
+	}
+	return content, nil
+}
+
+// Commit edits the single changed file. sourceCommitId makes the server
+// answer 409 if the file changed on the branch since base.
+func (b *bitbucketServerCommitter) Commit(base string, changes []stagedChange) (string, error) {
+	if len(changes) != 1 {
+		return "", fmt.Errorf("bitbucket server can only commit one file at a time, got %d changes", len(changes))
+	}
+	change := changes[0]
+	path := strings.Trim(change.Path, "/")
+	if change.Delete || fileMode(change.FileChange) != FileModeRegular {
+		return "", fmt.Errorf("path '%s': bitbucket server only supports writing regular files", path)
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	_ = form.WriteField("message", commitMessage(b.cfg))
+	_ = form.WriteField("branch", b.cfg.Branch)
+	if change.Current != nil {
+		_ = form.WriteField("sourceCommitId", base)
+	}
+	part, err := form.CreateFormFile("content", pathBase(path))
+	if err != nil {
+		return "", err
+	}
+	if _, err := part.Write(change.Content); err != nil {
+		return "", err
+	}
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+
+	headers := withHeader(bitbucketHeaders(b.cfg.Token), "Content-Type", form.FormDataContentType())
+	respBody, _, err := apiRequest("PUT", fmt.Sprintf("%s/browse/%s", b.repoURL(), escapePathSegments(path)), headers, &body)
+	if err != nil {
+		var apiErr *apiError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict {
+			return "", fmt.Errorf("%w: %s", errBranchMoved, apiErr.Body)
+		}
+		return "", fmt.Errorf("failed to create commit: %w", err)
+	}
+
+	var commitData struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(respBody, &commitData); err != nil {
+		return "", fmt.Errorf("failed to decode commit: %w", err)
+	}
+	return commitData.ID, nil
+}
+
+func (b *bitbucketServerCommitter) CommitURL(sha string) string {
+	web := b.cfg.APIURL
+	if i := strings.Index(web, "/rest/api/"); i >= 0 {
+		web = web[:i]
+	}
+	return fmt.Sprintf("%s/projects/%s/repos/%s/commits/%s", web, b.cfg.Owner, b.cfg.Repo, sha)
+}
+
+// getFile fetches the raw content of path at ref, or nil if absent.
+func (b *bitbucketServerCommitter) getFile(ref, filePath string) ([]byte, error) {
+	key := ref + ":" + filePath
+	if content, ok := b.files[key]; ok {
+		return content, nil
+	}
+
+	rawURL := fmt.Sprintf("%s/raw/%s?at=%s", b.repoURL(), escapePathSegments(filePath), url.QueryEscape(ref))
+	content, _, err := apiRequest("GET", rawURL, bitbucketHeaders(b.cfg.Token), nil)
+	if err != nil {
+		if isNotFound(err) {
+			b.files[key] = nil
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get file: %w", err)
+	}
+	if content == nil {
+		content = []byte{}
+	}
+
+	b.files[key] = content
+	return content, nil
+}
+
+func pathBase(p string) string {
+	return path.Base(strings.TrimRight(p, "/"))
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go.wasmcloud.dev/wadge"
+)
+
+// fakeBitbucket is an in-memory stand-in for the Bitbucket Cloud branches
+// and /src APIs of repository "owner/repo". It only tracks the branch head.
+type fakeBitbucket struct {
+	fakeHost
+}
+
+func newFakeBitbucket(files map[string]string) *fakeBitbucket {
+	f := &fakeBitbucket{}
+	f.init(files)
+	return f
+}
+
+func (f *fakeBitbucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer token" {
+		http.Error(w, `{"type":"error","error":{"message":"Unauthorized"}}`, http.StatusUnauthorized)
+		return
+	}
+
+	const prefix = "/2.0/repositories/owner/repo/"
+	if !strings.HasPrefix(r.URL.EscapedPath(), prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.EscapedPath(), prefix)
+
+	switch {
+	case r.Method == http.MethodGet && path == "refs/branches/main":
+		writeFakeJSON(w, map[string]interface{}{"target": map[string]string{"hash": f.head}})
+
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "src/"+f.head+"/"):
+		name, _ := url.PathUnescape(strings.TrimPrefix(path, "src/"+f.head+"/"))
+		content, ok := f.files[name]
+		if !ok {
+			http.Error(w, `{"type":"error","error":{"message":"No such file or directory"}}`, http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("format") == "meta" {
+			writeFakeJSON(w, map[string]interface{}{"type": "commit_file", "path": name, "attributes": []string{}})
+			return
+		}
+		_, _ = io.WriteString(w, content)
+
+	case r.Method == http.MethodPost && path == "src":
+		f.runBeforeCommit()
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.FormValue("branch") != "main" || r.FormValue("message") != "update" {
+			http.Error(w, `{"type":"error","error":{"message":"bad form"}}`, http.StatusBadRequest)
+			return
+		}
+		if r.FormValue("parents") != f.head {
+			http.Error(w, `{"type":"error","error":{"message":"Commit parents do not match the branch tip"}}`, http.StatusConflict)
+			return
+		}
+		for _, name := range r.MultipartForm.Value["files"] {
+			delete(f.files, strings.TrimPrefix(name, "/"))
+		}
+		for name, headers := range r.MultipartForm.File {
+			file, _ := headers[0].Open()
+			content, _ := io.ReadAll(file)
+			f.files[strings.TrimPrefix(name, "/")] = string(content)
+		}
+		f.head = f.nextCommit()
+		w.Header().Set("Location", "https://api.bitbucket.org/2.0/repositories/owner/repo/commit/"+f.head)
+		w.WriteHeader(http.StatusCreated)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestBitbucketDestination(url string) *DestinationConfig {
+	return &DestinationConfig{
+		Type:          DestinationBitbucket,
+		APIURL:        url + "/2.0",
+		Owner:         "owner",
+		Repo:          "repo",
+		Branch:        "main",
+		CommitMessage: "update",
+		Token:         "token",
+	}
+}
+
+// fakeBitbucketServer is an in-memory stand-in for the Bitbucket Server
+// branches, raw and browse APIs of repository "PROJ/repo".
+type fakeBitbucketServer struct {
+	fakeHost
+}
+
+func newFakeBitbucketServer(files map[string]string) *fakeBitbucketServer {
+	f := &fakeBitbucketServer{}
+	f.init(files)
+	return f
+}
+
+func (f *fakeBitbucketServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer token" {
+		http.Error(w, `{"errors":[{"message":"Authentication failed"}]}`, http.StatusUnauthorized)
+		return
+	}
+
+	const prefix = "/rest/api/1.0/projects/PROJ/repos/repo/"
+	if !strings.HasPrefix(r.URL.EscapedPath(), prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.EscapedPath(), prefix)
+
+	switch {
+	case r.Method == http.MethodGet && path == "branches":
+		// filterText matches substrings; pages hold one branch each, with
+		// the exact match last.
+		var matches []map[string]string
+		for _, name := range []string{"main-old", "release/main", "main"} {
+			if strings.Contains(name, r.URL.Query().Get("filterText")) {
+				matches = append(matches, map[string]string{"id": "refs/heads/" + name, "displayId": name, "latestCommit": f.nextCommit()})
+			}
+		}
+		matches[len(matches)-1]["latestCommit"] = f.head
+		start, _ := strconv.Atoi(r.URL.Query().Get("start"))
+		writeFakeJSON(w, map[string]interface{}{
+			"values":        matches[start : start+1],
+			"isLastPage":    start == len(matches)-1,
+			"nextPageStart": start + 1,
+		})
+
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "raw/"):
+		name, _ := url.PathUnescape(strings.TrimPrefix(path, "raw/"))
+		content, ok := f.files[name]
+		if !ok || r.URL.Query().Get("at") != f.head {
+			http.Error(w, `{"errors":[{"message":"The path does not exist"}]}`, http.StatusNotFound)
+			return
+		}
+		_, _ = io.WriteString(w, content)
+
+	case r.Method == http.MethodPut && strings.HasPrefix(path, "browse/"):
+		f.runBeforeCommit()
+		name, _ := url.PathUnescape(strings.TrimPrefix(path, "browse/"))
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, exists := f.files[name]
+		if source := r.FormValue("sourceCommitId"); exists && source != f.head {
+			http.Error(w, `{"errors":[{"message":"The file has been modified since sourceCommitId"}]}`, http.StatusConflict)
+			return
+		}
+		file, _, err := r.FormFile("content")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		content, _ := io.ReadAll(file)
+		f.files[name] = string(content)
+		f.head = f.nextCommit()
+		writeFakeJSON(w, map[string]string{"id": f.head})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestBitbucketServerDestination(url string) *DestinationConfig {
+	return &DestinationConfig{
+		Type:          DestinationBitbucketServer,
+		APIURL:        url + "/rest/api/1.0",
+		Owner:         "PROJ",
+		Repo:          "repo",
+		Branch:        "main",
+		CommitMessage: "update",
+		Token:         "token",
+	}
+}
+
+func TestBitbucketServerHeadSkipsPartialBranchMatches(t *testing.T) {
+	wadge.RunTest(t, func() {
+		fake := newFakeBitbucketServer(map[string]string{})
+		server := httptest.NewServer(fake)
+		defer server.Close()
+
+		head, err := newBitbucketServerCommitter(newTestBitbucketServerDestination(server.URL)).Head()
+		if err != nil || head != fake.head {
+			t.Fatalf("expected the head of main past the partial matches, got %q, %v", head, err)
+		}
+	})
+}
+
+func TestBitbucketServerHeadFailsOnUnknownBranch(t *testing.T) {
+	wadge.RunTest(t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeFakeJSON(w, map[string]interface{}{
+				"values":     []map[string]string{{"id": "refs/heads/main-old", "displayId": "main-old", "latestCommit": "abc"}},
+				"isLastPage": true,
+			})
+		}))
+		defer server.Close()
+
+		_, err := newBitbucketServerCommitter(newTestBitbucketServerDestination(server.URL)).Head()
+		if err == nil || !strings.Contains(err.Error(), "'main' not found") {
+			t.Fatalf("expected a substring match to be ignored, got %v", err)
+		}
+	})
+}
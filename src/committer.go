@@ -0,0 +1,458 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Git file modes accepted in a FileChange.
+const (
+	FileModeRegular    = "100644"
+	FileModeExecutable = "100755"
+	FileModeSymlink    = "120000"
+)
+
+// FileChange describes one path update within a commit. For symlinks Content
+// holds the link target; it is ignored when Delete is set.
+type FileChange struct {
+	Path    string
+	Content []byte
+	Mode    string
+	Delete  bool
+	// WriteMode merges Content with the file's current content on the branch,
+	// if any. Empty overwrites.
+	WriteMode string
+	// Key and SortBy are the jq expressions of the upsert write mode.
+	Key    string
+	SortBy string
+	// Retention trims the merged content before it is committed.
+	Retention RetentionConfig
+	// LFS stores Content in Git LFS and commits a pointer file to git.
+	LFS bool
+}
+
+// fileState is what a backend knows about an existing file at a commit.
+// Revision is backend specific, e.g. the last commit touching the file.
+type fileState struct {
+	Mode     string
+	SHA      string
+	Revision string
+}
+
+// stagedChange is a FileChange with its final content, ready to be committed,
+// along with the state of the path at the base commit (nil if absent) and the
+// content read from it to merge with (nil if it was not read).
+type stagedChange struct {
+	FileChange
+	Current  *fileState
+	Existing []byte
+}
+
+// committer is a destination backend that can write a set of changes to a
+// branch as a single commit. Lookup and ReadFile are only given commit IDs,
+// whose content never changes, so backends may cache their answers across the
+// attempts of a CommitToGit call.
+type committer interface {
+	// Head returns the commit the branch currently points at, or "" if the
+	// repository has no commits yet.
+	Head() (string, error)
+	// Lookup returns the state of path at commit ref, or nil if it is absent.
+	Lookup(ref, path string) (*fileState, error)
+	// ReadFile returns the content of path at commit ref.
+	ReadFile(ref, path string) ([]byte, error)
+	// Commit writes the changes on top of base, or as a root commit if base
+	// is "", and advances the branch. It returns errBranchMoved if the branch
+	// no longer allows that.
+	Commit(base string, changes []stagedChange) (string, error)
+	// CommitURL returns the web URL of a commit.
+	CommitURL(sha string) string
+}
+
+// Destination types accepted in DestinationConfig.Type. "bitbucket" is
+// Bitbucket Cloud; Server and Data Center use "bitbucket_server".
+const (
+	DestinationGitHub          = "github"
+	DestinationGitLab          = "gitlab"
+	DestinationGitea           = "gitea"
+	DestinationForgejo         = "forgejo"
+	DestinationBitbucket       = "bitbucket"
+	DestinationBitbucketServer = "bitbucket_server"
+	DestinationAzureDevOps     = "azure_devops"
+)
+
+func newCommitter(cfg *DestinationConfig) (committer, error) {
+	var c committer
+	switch cfg.Type {
+	case "", DestinationGitHub:
+		c = newGitHubCommitter(cfg)
+	case DestinationGitLab:
+		c = newGitLabCommitter(cfg)
+	case DestinationGitea, DestinationForgejo:
+		c = newGiteaCommitter(cfg)
+	case DestinationBitbucket:
+		c = newBitbucketCommitter(cfg)
+	case DestinationBitbucketServer:
+		c = newBitbucketServerCommitter(cfg)
+	case DestinationAzureDevOps:
+		c = newAzureCommitter(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported destination type '%s'", cfg.Type)
+	}
+
+	if cfg.APIURL == "" {
+		return nil, fmt.Errorf("destination api_url not configured")
+	}
+	if err := validateGitHubApp(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.CreateBranchFrom != "" && cfg.Type != "" && cfg.Type != DestinationGitHub {
+		return nil, fmt.Errorf("create_branch_from is only supported for github")
+	}
+	if cfg.Token == "" && !cfg.GitHubApp.configured() {
+		return nil, fmt.Errorf("git token not configured")
+	}
+	if err := validateCommitIdentity(cfg); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// validateCommitIdentity checks the author, committer and signing settings
+// against what the destination's API can record.
+func validateCommitIdentity(cfg *DestinationConfig) error {
+	if _, _, err := commitIdentities(cfg, time.Now()); err != nil {
+		return err
+	}
+
+	author := cfg.Author != IdentityConfig{}
+	committer := cfg.Committer != IdentityConfig{}
+	dated := cfg.Author.Date != "" || cfg.Committer.Date != ""
+	if committer && !author {
+		return fmt.Errorf("destination.committer needs destination.author")
+	}
+	switch cfg.Type {
+	case DestinationBitbucketServer:
+		if author || committer {
+			return fmt.Errorf("bitbucket server does not support setting the commit author")
+		}
+	case DestinationGitLab, DestinationBitbucket:
+		if committer || dated {
+			return fmt.Errorf("%s only supports setting the author name and email", cfg.Type)
+		}
+	}
+
+	if cfg.Signing == "" {
+		return nil
+	}
+	if cfg.Type != "" && cfg.Type != DestinationGitHub {
+		return fmt.Errorf("commit signing is only supported for github")
+	}
+	if cfg.Signing != SigningSSH && cfg.Signing != SigningOpenPGP {
+		return fmt.Errorf("unsupported signing format '%s'", cfg.Signing)
+	}
+	if cfg.SigningKey == "" {
+		return fmt.Errorf("signing key not configured")
+	}
+	if !author {
+		return fmt.Errorf("commit signing needs destination.author")
+	}
+	return nil
+}
+
+// CommitResult reports the outcome of a CommitToGit call. Unchanged is set
+// when the branch already held the requested content and no commit was made.
+type CommitResult struct {
+	SHA       string
+	ParentSHA string
+	URL       string
+	Branch    string
+	Retries   int
+	Unchanged bool
+	Files     []FileResult
+	// Changed lists the paths that differed from the base commit.
+	Changed     []string
+	PullRequest *PullRequestResult
+	// Warnings report follow-up steps that failed after the commit landed,
+	// such as labelling its pull request.
+	Warnings []string
+}
+
+// FileResult describes what happened to one path of a commit. WriteMode is
+// the mode actually applied: the requested merge mode only if there was
+// content to merge with, "overwrite" otherwise, or "delete". Dropped counts
+// the records removed by retention.
+type FileResult struct {
+	Path      string `json:"path"`
+	Bytes     int    `json:"bytes"`
+	WriteMode string `json:"write_mode"`
+	Status    string `json:"status"`
+	Dropped   int    `json:"dropped,omitempty"`
+}
+
+// errBranchMoved signals that the branch head changed between reading it and
+// updating it, so the commit has to be rebuilt on the new head.
+var errBranchMoved = errors.New("branch moved during commit")
+
+const (
+	maxCommitAttempts = 5
+	commitBackoffBase = 250 * time.Millisecond
+	commitBackoffMax  = 4 * time.Second
+)
+
+// CommitToGit applies all changes on top of the branch head as a single
+// commit, so the branch never holds a partially updated set of files. If
+// another writer moves the branch in the meantime, the commit is rebuilt
+// against the new head (including append merges) and retried.
+func CommitToGit(c committer, settings *SettingsConfig, changes []FileChange) (*CommitResult, error) {
+	if err := validateChanges(changes); err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		result, err := commitChanges(c, settings, changes)
+		if err == nil {
+			result.Retries = attempt
+			return result, nil
+		}
+		if !errors.Is(err, errBranchMoved) {
+			return nil, err
+		}
+		if attempt+1 >= maxCommitAttempts {
+			return nil, fmt.Errorf("giving up after %d attempts: %w", maxCommitAttempts, err)
+		}
+		time.Sleep(commitBackoff(attempt))
+	}
+}
+
+// commitChanges performs a single read-modify-write of the branch. Changes
+// whose blob and mode already match the base commit are dropped; if none are
+// left, no commit is made unless empty commits are allowed.
+func commitChanges(c committer, settings *SettingsConfig, changes []FileChange) (*CommitResult, error) {
+	base, err := c.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	resolved, files, err := stageChanges(c, base, changes)
+	if err != nil {
+		return nil, err
+	}
+
+	staged := make([]stagedChange, 0, len(resolved))
+	for i, change := range resolved {
+		if files[i].Status == "changed" {
+			staged = append(staged, change)
+		}
+	}
+
+	if len(staged) == 0 && !settings.AllowEmptyCommits {
+		return &CommitResult{SHA: base, URL: c.CommitURL(base), Unchanged: true, Files: files}, nil
+	}
+
+	sha, err := c.Commit(base, staged)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := make([]string, 0, len(staged))
+	for _, change := range staged {
+		changed = append(changed, change.Path)
+	}
+	return &CommitResult{
+		SHA:       sha,
+		ParentSHA: base,
+		URL:       c.CommitURL(sha),
+		Files:     files,
+		Changed:   changed,
+	}, nil
+}
+
+// stageChanges resolves every change against commit base, applying write
+// mode merges. Each change is returned with its final content and a FileResult
+// whose Status tells whether it differs from base.
+func stageChanges(c committer, base string, changes []FileChange) ([]stagedChange, []FileResult, error) {
+	staged := make([]stagedChange, 0, len(changes))
+	files := make([]FileResult, 0, len(changes))
+	for _, change := range changes {
+		current, err := c.Lookup(base, change.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if change.Delete {
+			file := FileResult{Path: change.Path, WriteMode: "delete", Status: "unchanged"}
+			if current != nil {
+				file.Status = "changed"
+			}
+			staged = append(staged, stagedChange{FileChange: change, Current: current})
+			files = append(files, file)
+			continue
+		}
+
+		file := FileResult{Path: change.Path, WriteMode: WriteModeOverwrite, Status: "unchanged"}
+		// An unreadable file must fail the run: merging with nothing would
+		// overwrite it with only the new content.
+		var existing []byte
+		if change.WriteMode != "" && change.WriteMode != WriteModeOverwrite && current != nil {
+			if existing, err = c.ReadFile(base, change.Path); err != nil {
+				return nil, nil, fmt.Errorf("path '%s': failed to read existing content: %w", change.Path, err)
+			}
+			if existing == nil {
+				existing = []byte{}
+			}
+			file.WriteMode = change.WriteMode
+		}
+		content, err := mergeContent(change, existing)
+		if err != nil {
+			return nil, nil, fmt.Errorf("path '%s': %w", change.Path, err)
+		}
+		if content, file.Dropped, err = applyRetention(change, content, time.Now()); err != nil {
+			return nil, nil, fmt.Errorf("path '%s': %w", change.Path, err)
+		}
+		file.Bytes = len(content)
+
+		change.Content = content
+		change.WriteMode = ""
+		if current == nil || current.Mode != fileMode(change) || current.SHA != gitBlobSHA(blobContent(change)) {
+			file.Status = "changed"
+		}
+		staged = append(staged, stagedChange{FileChange: change, Current: current, Existing: existing})
+		files = append(files, file)
+	}
+	return staged, files, nil
+}
+
+// CommitPreview is what CommitToGit would write on top of Base.
+type CommitPreview struct {
+	Base  string
+	Files []FilePreview
+}
+
+// FilePreview is the content a path would have after the commit, with a
+// unified diff against the base commit when it changes.
+type FilePreview struct {
+	FileResult
+	Content string `json:"content,omitempty"`
+	Diff    string `json:"diff,omitempty"`
+}
+
+// PreviewCommit resolves the changes against the branch head like
+// CommitToGit, but only reads from the destination. Files already read to
+// merge with are diffed without reading them again.
+func PreviewCommit(c committer, changes []FileChange) (*CommitPreview, error) {
+	if err := validateChanges(changes); err != nil {
+		return nil, err
+	}
+
+	base, err := c.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	staged, files, err := stageChanges(c, base, changes)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &CommitPreview{Base: base, Files: make([]FilePreview, 0, len(files))}
+	for i, change := range staged {
+		file := FilePreview{FileResult: files[i]}
+		// A nil side stands for an absent file in the diff.
+		var content []byte
+		if !change.Delete {
+			content = append([]byte{}, change.Content...)
+			file.Content = string(content)
+		}
+
+		if file.Status == "changed" {
+			existing := change.Existing
+			if existing == nil && change.Current != nil {
+				if existing, err = c.ReadFile(base, change.Path); err != nil {
+					return nil, err
+				}
+				existing = append([]byte{}, existing...)
+			}
+			file.Diff = unifiedDiff(strings.Trim(change.Path, "/"), existing, content)
+		}
+		preview.Files = append(preview.Files, file)
+	}
+	return preview, nil
+}
+
+// webURL derives the web UI root from an API root by dropping the given API
+// path suffix, e.g. "https://git.example.com/api/v1" to
+// "https://git.example.com".
+func webURL(apiURL, suffix string) string {
+	return strings.TrimSuffix(strings.TrimRight(apiURL, "/"), suffix)
+}
+
+// commitMessage returns the commit message, already rendered from its
+// template by groupByDestination.
+func commitMessage(cfg *DestinationConfig) string {
+	if cfg.CommitMessage == "" {
+		return "Update query results"
+	}
+	return cfg.CommitMessage
+}
+
+// gitBlobSHA computes the object ID git assigns to content stored as a blob.
+func gitBlobSHA(content []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(content))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// commitBackoff returns an exponential delay with jitter for the given retry.
+func commitBackoff(attempt int) time.Duration {
+	delay := commitBackoffBase << attempt
+	if delay > commitBackoffMax {
+		delay = commitBackoffMax
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func validateChanges(changes []FileChange) error {
+	if len(changes) == 0 {
+		return fmt.Errorf("no file changes to commit")
+	}
+	seen := make(map[string]bool, len(changes))
+	for _, change := range changes {
+		path := strings.Trim(change.Path, "/")
+		if path == "" {
+			return fmt.Errorf("file change with empty path")
+		}
+		if seen[path] {
+			return fmt.Errorf("duplicate file change for path '%s'", path)
+		}
+		seen[path] = true
+
+		switch fileMode(change) {
+		case FileModeRegular, FileModeExecutable, FileModeSymlink:
+		default:
+			return fmt.Errorf("path '%s': unsupported file mode %s", path, change.Mode)
+		}
+		if change.WriteMode == WriteModeAuto {
+			return fmt.Errorf("path '%s': write_mode must be resolved before committing", path)
+		}
+		if _, err := resolveWriteMode(change.WriteMode, path); err != nil {
+			return fmt.Errorf("path '%s': %w", path, err)
+		}
+		if change.WriteMode == WriteModeUpsert && change.Key == "" {
+			return fmt.Errorf("path '%s': upsert needs a key expression", path)
+		}
+	}
+	return nil
+}
+
+func fileMode(change FileChange) string {
+	if change.Mode == "" {
+		return FileModeRegular
+	}
+	return change.Mode
+}
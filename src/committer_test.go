@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"go.wasmcloud.dev/wadge"
+)
+
+// fakeHost is the state the in-memory destination fakes share: the files at
+// the head of branch main and the commit that last changed each of them.
+type fakeHost struct {
+	mu      sync.Mutex
+	head    string
+	files   map[string]string
+	changed map[string]string
+	commits int
+	// beforeCommit runs once before the next commit is applied, e.g. to
+	// simulate a concurrent push.
+	beforeCommit func(f *fakeHost)
+}
+
+func (f *fakeHost) init(files map[string]string) {
+	f.files = map[string]string{}
+	f.changed = map[string]string{}
+	f.push(files)
+}
+
+func (f *fakeHost) host() *fakeHost {
+	return f
+}
+
+func (f *fakeHost) nextCommit() string {
+	f.commits++
+	return fmt.Sprintf("%040x", f.commits)
+}
+
+// push records a commit on top of the branch head that sets files, as
+// another writer would.
+func (f *fakeHost) push(files map[string]string) {
+	f.head = f.nextCommit()
+	for path, content := range files {
+		f.files[path] = content
+		f.changed[path] = f.head
+	}
+}
+
+// runBeforeCommit runs and clears the beforeCommit hook.
+func (f *fakeHost) runBeforeCommit() {
+	if hook := f.beforeCommit; hook != nil {
+		f.beforeCommit = nil
+		hook(f)
+	}
+}
+
+// file returns the content of path at the branch head.
+func (f *fakeHost) file(path string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	content, ok := f.files[path]
+	return content, ok
+}
+
+// fakeBackend is a destination fake the shared committer tests run against.
+type fakeBackend interface {
+	http.Handler
+	host() *fakeHost
+}
+
+// testBackends are the destination backends besides GitHub, each with its
+// fake and a destination pointing at it.
+var testBackends = []struct {
+	name        string
+	fake        func(files map[string]string) fakeBackend
+	destination func(url string) *DestinationConfig
+	// singleFile backends commit one file at a time.
+	singleFile bool
+}{
+	{name: "gitlab", fake: func(files map[string]string) fakeBackend { return newFakeGitLab(files) }, destination: newTestGitLabDestination},
+	{name: "gitea", fake: func(files map[string]string) fakeBackend { return newFakeGitea(files) }, destination: newTestGiteaDestination},
+	{name: "bitbucket", fake: func(files map[string]string) fakeBackend { return newFakeBitbucket(files) }, destination: newTestBitbucketDestination},
+	{name: "bitbucket_server", fake: func(files map[string]string) fakeBackend { return newFakeBitbucketServer(files) }, destination: newTestBitbucketServerDestination, singleFile: true},
+	{name: "azure_devops", fake: func(files map[string]string) fakeBackend { return newFakeAzure(files) }, destination: newTestAzureDestination},
+}
+
+func TestCommittersWriteAllChangesInOneCommit(t *testing.T) {
+	for _, backend := range testBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			wadge.RunTest(t, func() {
+				fake := backend.fake(map[string]string{"reports/power #1.csv": "a\n", "old.txt": "x", "same.json": "{}"})
+				server := httptest.NewServer(fake)
+				defer server.Close()
+
+				changes := []FileChange{
+					{Path: "reports/power #1.csv", Content: []byte("b\n"), WriteMode: WriteModeAppend},
+					{Path: "new.json", Content: []byte("[]")},
+					{Path: "old.txt", Delete: true},
+					{Path: "same.json", Content: []byte("{}")},
+				}
+				if backend.singleFile {
+					changes = changes[:1]
+				}
+
+				c, err := newCommitter(backend.destination(server.URL))
+				if err != nil {
+					t.Fatalf("newCommitter failed: %s", err)
+				}
+				result, err := CommitToGit(c, &SettingsConfig{}, changes)
+				if err != nil {
+					t.Fatalf("CommitToGit failed: %s", err)
+				}
+
+				host := fake.host()
+				if result.SHA != host.head {
+					t.Fatalf("expected commit %s to be the branch head %s", result.SHA, host.head)
+				}
+				if got, _ := host.file("reports/power #1.csv"); got != "a\nb\n" {
+					t.Fatalf("unexpected power #1.csv content: %q", got)
+				}
+				if backend.singleFile {
+					return
+				}
+				if got, _ := host.file("new.json"); got != "[]" {
+					t.Fatalf("unexpected new.json content: %q", got)
+				}
+				if _, ok := host.file("old.txt"); ok {
+					t.Fatalf("expected old.txt to be deleted")
+				}
+				if len(result.Changed) != 3 {
+					t.Fatalf("expected unchanged same.json to be left out of the commit, got %v", result.Changed)
+				}
+			})
+		})
+	}
+}
+
+func TestCommittersRetryWhenBranchMoved(t *testing.T) {
+	for _, backend := range testBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			wadge.RunTest(t, func() {
+				fake := backend.fake(map[string]string{"data.csv": "a\n"})
+				fake.host().beforeCommit = func(f *fakeHost) {
+					f.push(map[string]string{"data.csv": "a\nconcurrent\n"})
+				}
+				server := httptest.NewServer(fake)
+				defer server.Close()
+
+				c, err := newCommitter(backend.destination(server.URL))
+				if err != nil {
+					t.Fatalf("newCommitter failed: %s", err)
+				}
+				result, err := CommitToGit(c, &SettingsConfig{}, []FileChange{
+					{Path: "data.csv", Content: []byte("b\n"), WriteMode: WriteModeAppend},
+				})
+				if err != nil {
+					t.Fatalf("CommitToGit failed: %s", err)
+				}
+				if result.Retries != 1 {
+					t.Fatalf("expected 1 retry, got %d", result.Retries)
+				}
+				if got, _ := fake.host().file("data.csv"); got != "a\nconcurrent\nb\n" {
+					t.Fatalf("append was not re-applied on the new head: %q", got)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Settings    SettingsConfig          `yaml:"settings"`
+	Source      SourceConfig            `yaml:"source"`
+	Sources     map[string]SourceConfig `yaml:"sources"`
+	Queries     []QueryConfig           `yaml:"queries"`
+	Destination DestinationConfig       `yaml:"destination"`
+}
+
+type SettingsConfig struct {
+	// WriteMode is the default for queries without their own write_mode.
+	WriteMode string `yaml:"write_mode"`
+	// AllowEmptyCommits commits even when nothing changed, as a heartbeat.
+	AllowEmptyCommits bool `yaml:"allow_empty_commits"`
+	// Retention is the default for queries without their own retention.
+	Retention RetentionConfig `yaml:"retention"`
+}
+
+// RetentionConfig bounds files that grow with every run. Records are CSV
+// rows, JSON array elements or lines depending on the write mode.
+type RetentionConfig struct {
+	MaxRecords int `yaml:"max_records"`
+	MaxAgeDays int `yaml:"max_age_days"`
+	// Timestamp is a jq expression returning each record's time, as Unix
+	// seconds or a date, for max_age_days.
+	Timestamp string `yaml:"timestamp"`
+	// HeaderLines are always kept at the top of text files; .csv files
+	// keep one by default.
+	HeaderLines int `yaml:"header_lines"`
+	// Rollover starts a new date-partitioned file every day, month or year.
+	Rollover string `yaml:"rollover"`
+}
+
+type SourceConfig struct {
+	Method  string            `yaml:"method"`
+	Headers map[string]string `yaml:"headers"`
+	Auth    AuthConfig        `yaml:"auth"`
+	// Body is sent as the request body, e.g. for POST APIs. YAML objects
+	// and lists are sent as JSON.
+	Body RequestBody `yaml:"body"`
+	// ContentType of the body, application/json by default for JSON bodies.
+	ContentType string `yaml:"content_type"`
+	// GraphQL POSTs a GraphQL query instead of Body.
+	GraphQL *GraphQLConfig `yaml:"graphql"`
+	// Pagination fetches every page and merges their records into one
+	// array before the query runs.
+	Pagination *PaginationConfig `yaml:"pagination"`
+}
+
+// RequestBody is a raw request body. In YAML it is either a string, sent
+// as is, or an object or list, sent as JSON.
+type RequestBody string
+
+func (b *RequestBody) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*b = RequestBody(node.Value)
+		return nil
+	}
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode body as JSON: %w", err)
+	}
+	*b = RequestBody(data)
+	return nil
+}
+
+type GraphQLConfig struct {
+	Query     string                 `yaml:"query"`
+	Variables map[string]interface{} `yaml:"variables"`
+}
+
+type PaginationConfig struct {
+	// Type is link (Link: rel="next" headers), page, offset or cursor.
+	Type string `yaml:"type"`
+	// Items is a jq path to the records of a page, the page itself by
+	// default.
+	Items string `yaml:"items"`
+	// MaxPages caps the pages fetched, 10 by default.
+	MaxPages int `yaml:"max_pages"`
+	// Param is the URL parameter carrying the page number (page), offset
+	// (offset) or cursor (cursor); for GraphQL cursors, the variable.
+	Param string `yaml:"param"`
+	// SizeParam and Size ask for pages of Size records, per_page (link and
+	// page) or limit (offset and cursor) by default. A shorter page is the
+	// last one, except for link.
+	SizeParam string `yaml:"size_param"`
+	Size      int    `yaml:"size"`
+	// Start is the first page number, 1 by default.
+	Start int `yaml:"start"`
+	// Cursor is a jq path to the next page's cursor in a page; null or an
+	// empty string ends the pagination.
+	Cursor string `yaml:"cursor"`
+}
+
+// AuthConfig authenticates source requests with secrets read from the
+// environment variables it names. source: defaults to basic auth from
+// Q2GIT_SOURCE_USERNAME and Q2GIT_SOURCE_PASSWORD.
+type AuthConfig struct {
+	// Type is basic (default), bearer, api_key, oauth2 or proxy.
+	Type        string `yaml:"type"`
+	UsernameEnv string `yaml:"username_env"`
+	PasswordEnv string `yaml:"password_env"`
+	// TokenEnv holds the bearer token.
+	TokenEnv string `yaml:"token_env"`
+	// KeyEnv holds the API key, sent in Header (X-API-Key by default) or in
+	// the QueryParam URL parameter.
+	KeyEnv     string `yaml:"key_env"`
+	Header     string `yaml:"header"`
+	QueryParam string `yaml:"query_param"`
+	// TokenURL issues OAuth2 access tokens for the client credentials.
+	TokenURL        string   `yaml:"token_url"`
+	ClientIDEnv     string   `yaml:"client_id_env"`
+	ClientSecretEnv string   `yaml:"client_secret_env"`
+	Scopes          []string `yaml:"scopes"`
+	// ProxyURL is the proxy on the host that proxy auth sends requests to;
+	// any authentication towards the source is up to the proxy.
+	ProxyURL string `yaml:"proxy_url"`
+
+	Username     string `yaml:"-"`
+	Password     string `yaml:"-"`
+	Token        string `yaml:"-"`
+	Key          string `yaml:"-"`
+	ClientID     string `yaml:"-"`
+	ClientSecret string `yaml:"-"`
+}
+
+type QueryConfig struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	URL         string `yaml:"url"`
+	Query       string `yaml:"query"`
+
+	// Source selects a profile from sources: instead of source:. The
+	// inline method, headers, auth and body override it; headers are added
+	// to the profile's.
+	Source       string `yaml:"source"`
+	SourceConfig `yaml:",inline"`
+
+	// Optional per-query routing; empty fields fall back to destination.
+	OutputPath string `yaml:"output_path"`
+	Owner      string `yaml:"owner"`
+	Repo       string `yaml:"repo"`
+	Branch     string `yaml:"branch"`
+
+	// WriteMode overrides settings.write_mode for this query.
+	WriteMode string `yaml:"write_mode"`
+	// Key and SortBy are jq expressions evaluated per record in upsert mode:
+	// records with equal keys are replaced, and the file is optionally sorted.
+	Key    string `yaml:"key"`
+	SortBy string `yaml:"sort_by"`
+	// Retention overrides settings.retention for this query.
+	Retention *RetentionConfig `yaml:"retention"`
+	// LFS stores the query's output file in Git LFS.
+	LFS bool `yaml:"lfs"`
+}
+
+type DestinationConfig struct {
+	Type          string `yaml:"type"`
+	APIURL        string `yaml:"api_url"`
+	Owner         string `yaml:"owner"`
+	Repo          string `yaml:"repo"`
+	Branch        string `yaml:"branch"`
+	OutputPath    string `yaml:"output_path"`
+	CommitMessage string `yaml:"commit_message"`
+	Token         string `yaml:"-"`
+	// CreateBranchFrom names the branch a missing Branch is created from.
+	// Only supported for GitHub.
+	CreateBranchFrom string `yaml:"create_branch_from"`
+	// LFS stores matching files in Git LFS. Only supported for GitHub.
+	LFS LFSConfig `yaml:"lfs"`
+	// GitHubApp authenticates as a GitHub App installation instead of with
+	// Q2GIT_GITHUB_TOKEN.
+	GitHubApp GitHubAppConfig `yaml:"github_app"`
+
+	// Mode is "direct" (default) or "pull_request".
+	Mode        string            `yaml:"mode"`
+	PullRequest PullRequestConfig `yaml:"pull_request"`
+
+	// Author and Committer default to the token's user. Committer defaults
+	// to Author when only the author is set.
+	Author    IdentityConfig `yaml:"author"`
+	Committer IdentityConfig `yaml:"committer"`
+	// Signing is "ssh" or "openpgp" to sign commits with the key in
+	// Q2GIT_SIGNING_KEY. Only supported for GitHub.
+	Signing    string `yaml:"signing"`
+	SigningKey string `yaml:"-"`
+}
+
+type LFSConfig struct {
+	// Paths are patterns of files stored in LFS, e.g. "*.json" or
+	// "snapshots/*.json".
+	Paths []string `yaml:"paths"`
+	// URL overrides the LFS server, "<repository>.git/info/lfs" by default.
+	URL string `yaml:"url"`
+}
+
+type GitHubAppConfig struct {
+	// AppID is the app's ID or client ID.
+	AppID          string `yaml:"app_id"`
+	InstallationID string `yaml:"installation_id"`
+	// PrivateKey is read from Q2GIT_GITHUB_APP_PRIVATE_KEY.
+	PrivateKey string `yaml:"-"`
+}
+
+type IdentityConfig struct {
+	Name  string `yaml:"name"`
+	Email string `yaml:"email"`
+	// Date is an RFC 3339 timestamp; empty uses the time of the commit.
+	Date string `yaml:"date"`
+}
+
+type PullRequestConfig struct {
+	// Branch overrides the generated "<branch_prefix><query>" head branch.
+	Branch        string   `yaml:"branch"`
+	BranchPrefix  string   `yaml:"branch_prefix"`
+	Title         string   `yaml:"title"`
+	Labels        []string `yaml:"labels"`
+	Reviewers     []string `yaml:"reviewers"`
+	TeamReviewers []string `yaml:"team_reviewers"`
+	AutoMerge     bool     `yaml:"auto_merge"`
+	// MergeMethod for auto-merge: merge (default), squash or rebase.
+	MergeMethod string `yaml:"merge_method"`
+}
+
+// tokenEnvVars names the environment variable holding the destination token
+// for each destination type.
+var tokenEnvVars = map[string]string{
+	DestinationGitHub:          "Q2GIT_GITHUB_TOKEN",
+	DestinationGitLab:          "Q2GIT_GITLAB_TOKEN",
+	DestinationGitea:           "Q2GIT_GITEA_TOKEN",
+	DestinationForgejo:         "Q2GIT_FORGEJO_TOKEN",
+	DestinationBitbucket:       "Q2GIT_BITBUCKET_TOKEN",
+	DestinationBitbucketServer: "Q2GIT_BITBUCKET_TOKEN",
+	DestinationAzureDevOps:     "Q2GIT_AZURE_DEVOPS_TOKEN",
+}
+
+var defaultAPIURLs = map[string]string{
+	DestinationGitHub:      "https://api.github.com",
+	DestinationGitLab:      "https://gitlab.com/api/v4",
+	DestinationBitbucket:   "https://api.bitbucket.org/2.0",
+	DestinationAzureDevOps: "https://dev.azure.com",
+}
+
+// LoadConfig reads the YAML config from Q2GIT_CONFIG and fills in secrets
+// from their dedicated environment variables.
+func LoadConfig() (*Config, error) {
+	raw := os.Getenv("Q2GIT_CONFIG")
+	if raw == "" {
+		return nil, fmt.Errorf("Q2GIT_CONFIG is not set")
+	}
+
+	var config Config
+	if err := yaml.Unmarshal([]byte(raw), &config); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	if config.Source.Method == "" {
+		config.Source.Method = "GET"
+	}
+	if config.Source.Body != "" && config.Source.GraphQL != nil {
+		return nil, fmt.Errorf("source: body and graphql are mutually exclusive")
+	}
+	for name, source := range config.Sources {
+		if source.Method == "" {
+			source.Method = "GET"
+		}
+		if source.Body != "" && source.GraphQL != nil {
+			return nil, fmt.Errorf("source '%s': body and graphql are mutually exclusive", name)
+		}
+		if err := source.Auth.validate(); err != nil {
+			return nil, fmt.Errorf("source '%s': %w", name, err)
+		}
+		if err := source.Pagination.validate(); err != nil {
+			return nil, fmt.Errorf("source '%s': %w", name, err)
+		}
+		source.Auth.load()
+		config.Sources[name] = source
+	}
+	for i := range config.Queries {
+		q := &config.Queries[i]
+		if _, ok := config.Sources[q.Source]; q.Source != "" && !ok {
+			return nil, fmt.Errorf("query '%s': unknown source '%s'", q.Name, q.Source)
+		}
+		if q.Body != "" && q.GraphQL != nil {
+			return nil, fmt.Errorf("query '%s': body and graphql are mutually exclusive", q.Name)
+		}
+		if err := q.Auth.validate(); err != nil {
+			return nil, fmt.Errorf("query '%s': %w", q.Name, err)
+		}
+		if err := q.Pagination.validate(); err != nil {
+			return nil, fmt.Errorf("query '%s': %w", q.Name, err)
+		}
+		q.Auth.load()
+	}
+	if config.Destination.Type == "" {
+		config.Destination.Type = DestinationGitHub
+	}
+	if config.Destination.APIURL == "" {
+		config.Destination.APIURL = defaultAPIURLs[config.Destination.Type]
+	}
+	// The other backends commit through file APIs that need a file change.
+	if config.Settings.AllowEmptyCommits && config.Destination.Type != DestinationGitHub {
+		return nil, fmt.Errorf("settings.allow_empty_commits is only supported for github")
+	}
+	if config.Destination.Branch == "" {
+		config.Destination.Branch = "main"
+	}
+
+	config.Destination.Token = os.Getenv(tokenEnvVars[config.Destination.Type])
+	config.Destination.SigningKey = os.Getenv("Q2GIT_SIGNING_KEY")
+	config.Destination.GitHubApp.PrivateKey = os.Getenv("Q2GIT_GITHUB_APP_PRIVATE_KEY")
+	if !config.Source.Auth.configured() {
+		config.Source.Auth.UsernameEnv = "Q2GIT_SOURCE_USERNAME"
+		config.Source.Auth.PasswordEnv = "Q2GIT_SOURCE_PASSWORD"
+	}
+	if err := config.Source.Auth.validate(); err != nil {
+		return nil, fmt.Errorf("source: %w", err)
+	}
+	if err := config.Source.Pagination.validate(); err != nil {
+		return nil, fmt.Errorf("source: %w", err)
+	}
+	config.Source.Auth.load()
+
+	return &config, nil
+}
+
+// load reads the credentials from the environment variables named in the
+// config.
+func (a *AuthConfig) load() {
+	for _, secret := range []struct {
+		env   string
+		value *string
+	}{
+		{a.UsernameEnv, &a.Username},
+		{a.PasswordEnv, &a.Password},
+		{a.TokenEnv, &a.Token},
+		{a.KeyEnv, &a.Key},
+		{a.ClientIDEnv, &a.ClientID},
+		{a.ClientSecretEnv, &a.ClientSecret},
+	} {
+		if secret.env != "" {
+			*secret.value = os.Getenv(secret.env)
+		}
+	}
+}
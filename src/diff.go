@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const diffContext = 3
+
+// maxDiffEdits caps the edit distance diffLines searches. The Myers trace
+// grows with its square, so files that differ in more lines are shown as
+// replaced whole instead.
+const maxDiffEdits = 1000
+
+// diffOp is one line of an edit script: ' ' keeps a[A], '-' deletes a[A] and
+// '+' inserts b[B].
+type diffOp struct {
+	Kind byte
+	A, B int
+}
+
+// unifiedDiff renders the line changes from old to new in the unified format
+// used by `git diff`, with three lines of context. A nil side stands for an
+// absent file.
+func unifiedDiff(path string, old, new []byte) string {
+	a, b := splitLines(old), splitLines(new)
+	ops := diffLines(a, b)
+
+	var sb strings.Builder
+	oldName, newName := "a/"+path, "b/"+path
+	if old == nil {
+		oldName = "/dev/null"
+	}
+	if new == nil {
+		newName = "/dev/null"
+	}
+
+	for _, hunk := range diffHunks(ops) {
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+		}
+
+		oldCount, newCount := 0, 0
+		for _, op := range hunk {
+			if op.Kind != '+' {
+				oldCount++
+			}
+			if op.Kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(hunk[0].A, oldCount), hunkRange(hunk[0].B, newCount))
+
+		for _, op := range hunk {
+			var line string
+			if op.Kind == '+' {
+				line = b[op.B]
+			} else {
+				line = a[op.A]
+			}
+			sb.WriteByte(op.Kind)
+			sb.WriteString(line)
+			if !strings.HasSuffix(line, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+	return sb.String()
+}
+
+// hunkRange formats the start,count pair of a hunk header. An empty range
+// names the line before it, as diff(1) does.
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, count)
+	}
+}
+
+// diffHunks splits an edit script into hunks of changes surrounded by up to
+// diffContext unchanged lines, merging hunks whose context would overlap.
+func diffHunks(ops []diffOp) [][]diffOp {
+	var hunks [][]diffOp
+	start, end := -1, -1
+	for i, op := range ops {
+		if op.Kind == ' ' {
+			continue
+		}
+		if start >= 0 && i-end > diffContext {
+			hunks = append(hunks, ops[start:end])
+			start = -1
+		}
+		if start < 0 {
+			start = i - diffContext
+			if start < 0 {
+				start = 0
+			}
+		}
+		end = i + 1 + diffContext
+		if end > len(ops) {
+			end = len(ops)
+		}
+	}
+	if start >= 0 {
+		hunks = append(hunks, ops[start:end])
+	}
+	return hunks
+}
+
+// diffLines computes a shortest edit script from a to b with Myers' O(ND)
+// algorithm, or replaces all of a with b beyond maxDiffEdits edits.
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	max := n + m
+	off := max + 1
+	v := make([]int, 2*max+3)
+	// trace[d] holds the furthest x reached on diagonals -d..d after d edits.
+	var trace [][]int
+
+	for d := 0; d <= max; d++ {
+		if d > maxDiffEdits {
+			return diffReplace(n, m)
+		}
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+
+			if x >= n && y >= m {
+				return diffBacktrack(trace, n, m)
+			}
+		}
+		trace = append(trace, append([]int(nil), v[off-d:off+d+1]...))
+	}
+	return nil
+}
+
+// diffReplace is the edit script deleting all n old lines and inserting all
+// m new ones.
+func diffReplace(n, m int) []diffOp {
+	ops := make([]diffOp, 0, n+m)
+	for i := 0; i < n; i++ {
+		ops = append(ops, diffOp{Kind: '-', A: i, B: 0})
+	}
+	for j := 0; j < m; j++ {
+		ops = append(ops, diffOp{Kind: '+', A: n, B: j})
+	}
+	return ops
+}
+
+// diffBacktrack walks the Myers trace back from (n, m) and returns the edit
+// script in forward order.
+func diffBacktrack(trace [][]int, n, m int) []diffOp {
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace); d > 0; d-- {
+		prev := trace[d-1]
+		k := x - y
+
+		prevK := k - 1
+		if k == -d || (k != d && prev[k-1+d-1] < prev[k+1+d-1]) {
+			prevK = k + 1
+		}
+		prevX := prev[prevK+d-1]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, diffOp{Kind: ' ', A: x, B: y})
+		}
+		if x == prevX {
+			ops = append(ops, diffOp{Kind: '+', A: x, B: prevY})
+		} else {
+			ops = append(ops, diffOp{Kind: '-', A: prevX, B: y})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		ops = append(ops, diffOp{Kind: ' ', A: x, B: y})
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// splitLines splits content into lines, each keeping its trailing newline.
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
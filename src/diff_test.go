@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	old := []byte("a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n")
+	new := []byte("a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\n")
+	want := "--- a/x.txt\n+++ b/x.txt\n" +
+		"@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n" +
+		"@@ -8,3 +8,4 @@\n h\n i\n j\n+k\n"
+	if got := unifiedDiff("x.txt", old, new); got != want {
+		t.Fatalf("unexpected diff:\nwant %q\ngot  %q", want, got)
+	}
+}
+
+func TestUnifiedDiffReplacesFilesBeyondEditCap(t *testing.T) {
+	// Blocks of 12 changed lines separated by 8 unchanged ones would give
+	// one hunk per block, but the edit distance is over the cap.
+	var old, new strings.Builder
+	const lines = 2000
+	for i := 0; i < lines; i++ {
+		if i%20 < 8 {
+			fmt.Fprintf(&old, "same %d\n", i)
+			fmt.Fprintf(&new, "same %d\n", i)
+			continue
+		}
+		fmt.Fprintf(&old, "old %d\n", i)
+		fmt.Fprintf(&new, "new %d\n", i)
+	}
+
+	diff := unifiedDiff("x.txt", []byte(old.String()), []byte(new.String()))
+	header := fmt.Sprintf("--- a/x.txt\n+++ b/x.txt\n@@ -1,%d +1,%d @@\n-same 0\n", lines, lines)
+	if !strings.HasPrefix(diff, header) || strings.Count(diff, "@@ ") != 1 {
+		t.Fatalf("expected a single whole-file hunk, got %.200q", diff)
+	}
+	if strings.Contains(diff, "\n same") || !strings.HasSuffix(diff, fmt.Sprintf("+new %d\n", lines-1)) {
+		t.Fatalf("expected all old lines replaced by all new lines")
+	}
+}
@@ -1,44 +1,177 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"time"
-
-	"go.wasmcloud.dev/component/net/wasihttp"
+	"strings"
 )
 
-func FetchData(cfg *SourceConfig, url string) ([]byte, error) {
-	req, err := http.NewRequest(cfg.Method, url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+// querySource resolves the source a query fetches from: its profile from
+// sources:, or source:, overridden by the query's own method, auth, body
+// or graphql, content type and pagination, with its headers added.
+func querySource(config *Config, q QueryConfig) (*SourceConfig, error) {
+	base := config.Source
+	if q.Source != "" {
+		var ok bool
+		if base, ok = config.Sources[q.Source]; !ok {
+			return nil, fmt.Errorf("unknown source '%s'", q.Source)
+		}
 	}
 
-	for key, value := range cfg.Headers {
-		req.Header.Set(key, value)
+	source := base
+	source.Headers = make(map[string]string, len(base.Headers)+len(q.Headers))
+	for key, value := range base.Headers {
+		source.Headers[key] = value
+	}
+	for key, value := range q.Headers {
+		source.Headers[key] = value
+	}
+	if q.Method != "" {
+		source.Method = q.Method
+	}
+	if q.Auth.configured() {
+		source.Auth = q.Auth
+	}
+	if q.Body != "" {
+		source.Body = q.Body
+		source.GraphQL = nil
+	}
+	if q.GraphQL != nil {
+		source.GraphQL = q.GraphQL
+		source.Body = ""
+	}
+	if q.ContentType != "" {
+		source.ContentType = q.ContentType
+	}
+	if q.Pagination != nil {
+		source.Pagination = q.Pagination
 	}
+	return &source, nil
+}
+
+// requestBody returns the body to send and its default content type.
+// GraphQL queries are sent as {"query", "variables"} JSON.
+func requestBody(cfg *SourceConfig) ([]byte, string, error) {
+	if cfg.GraphQL != nil {
+		body, err := json.Marshal(map[string]interface{}{
+			"query":     cfg.GraphQL.Query,
+			"variables": cfg.GraphQL.Variables,
+		})
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to encode GraphQL request: %w", err)
+		}
+		return body, "application/json", nil
+	}
+	if cfg.Body == "" {
+		return nil, "", nil
+	}
+	body := []byte(cfg.Body)
+	if json.Valid(body) {
+		return body, "application/json", nil
+	}
+	return body, "", nil
+}
 
-	if cfg.Auth.Username != "" && cfg.Auth.Password != "" {
-		req.SetBasicAuth(cfg.Auth.Username, cfg.Auth.Password)
+// graphQLErrors fails a GraphQL response carrying errors, which servers
+// return with status 200.
+func graphQLErrors(data []byte) error {
+	var resp struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
 	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return fmt.Errorf("failed to decode GraphQL response: %w", err)
+	}
+	if len(resp.Errors) == 0 {
+		return nil
+	}
+	messages := make([]string, len(resp.Errors))
+	for i, e := range resp.Errors {
+		messages[i] = e.Message
+	}
+	return fmt.Errorf("GraphQL errors: %s", strings.Join(messages, "; "))
+}
 
-	client := &http.Client{
-		Transport: &wasihttp.Transport{
-			ConnectTimeout: 30 * time.Second,
-		},
+// FetchData fetches a query's data: one response, or the records of every
+// page merged into one array when the source is paginated.
+func FetchData(cfg *SourceConfig, url string) ([]byte, error) {
+	if cfg.Pagination != nil {
+		return fetchPages(cfg, url)
 	}
+	data, _, err := fetchPage(cfg, url)
+	return data, err
+}
 
-	resp, err := client.Do(req)
+// fetchPage fetches one response, returning its body and headers.
+func fetchPage(cfg *SourceConfig, url string) ([]byte, http.Header, error) {
+	resp, err := sendSourceRequest(cfg, url)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized && cfg.Auth.Type == AuthOAuth2 {
+		// The access token may have been revoked before its expiry.
+		resp.Body.Close()
+		forgetOAuth2Token(&cfg.Auth)
+		resp, err = sendSourceRequest(cfg, url)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch data: %w", err)
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("HTTP error %d: %s", resp.StatusCode, string(body))
+		return nil, nil, fmt.Errorf("HTTP error %d: %s", resp.StatusCode, string(body))
 	}
 
-	return io.ReadAll(resp.Body)
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if cfg.GraphQL != nil {
+		if err := graphQLErrors(data); err != nil {
+			return nil, nil, err
+		}
+	}
+	return data, resp.Header, nil
+}
+
+// sendSourceRequest sends the request described by cfg to url.
+func sendSourceRequest(cfg *SourceConfig, url string) (*http.Response, error) {
+	payload, contentType, err := requestBody(cfg)
+	if err != nil {
+		return nil, err
+	}
+	method := cfg.Method
+	if cfg.GraphQL != nil {
+		method = http.MethodPost
+	}
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	for key, value := range cfg.Headers {
+		req.Header.Set(key, value)
+	}
+	if cfg.ContentType != "" {
+		req.Header.Set("Content-Type", cfg.ContentType)
+	} else if contentType != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	if err := applyAuth(req, &cfg.Auth); err != nil {
+		return nil, err
+	}
+
+	resp, err := newHTTPClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch data: %w", err)
+	}
+	return resp, nil
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.wasmcloud.dev/wadge"
+)
+
+func TestLoadConfigResolvesSourceProfiles(t *testing.T) {
+	t.Setenv("Q2GIT_SOURCE_USERNAME", "global-user")
+	t.Setenv("Q2GIT_SOURCE_PASSWORD", "global-pass")
+	t.Setenv("PROM_USER", "prom-user")
+	t.Setenv("PROM_PASS", "prom-pass")
+	t.Setenv("Q2GIT_CONFIG", `
+source:
+  headers:
+    Accept: application/json
+sources:
+  prometheus:
+    headers:
+      Accept: application/json
+      X-Scope-OrgID: tenant-a
+    auth:
+      username_env: PROM_USER
+      password_env: PROM_PASS
+queries:
+  - name: default
+    url: https://api.example.com/items
+    query: '.'
+  - name: power
+    source: prometheus
+    url: https://prometheus.example.com/api/v1/query
+    method: POST
+    headers:
+      X-Scope-OrgID: tenant-b
+      Content-Type: application/x-www-form-urlencoded
+    body: query=power
+    query: '.'
+`)
+
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %s", err)
+	}
+
+	source, err := querySource(config, config.Queries[0])
+	if err != nil {
+		t.Fatalf("querySource failed: %s", err)
+	}
+	if source.Method != "GET" || source.Auth.Username != "global-user" || source.Auth.Password != "global-pass" {
+		t.Fatalf("expected the global source, got %+v", source)
+	}
+
+	source, err = querySource(config, config.Queries[1])
+	if err != nil {
+		t.Fatalf("querySource failed: %s", err)
+	}
+	if source.Method != "POST" || source.Body != "query=power" {
+		t.Fatalf("expected the query's method and body, got %+v", source)
+	}
+	if source.Auth.Username != "prom-user" || source.Auth.Password != "prom-pass" {
+		t.Fatalf("expected the profile's credentials, got %+v", source.Auth)
+	}
+	want := map[string]string{
+		"Accept":        "application/json",
+		"X-Scope-OrgID": "tenant-b",
+		"Content-Type":  "application/x-www-form-urlencoded",
+	}
+	if len(source.Headers) != len(want) {
+		t.Fatalf("unexpected headers: %v", source.Headers)
+	}
+	for key, value := range want {
+		if source.Headers[key] != value {
+			t.Fatalf("unexpected headers: %v", source.Headers)
+		}
+	}
+	if len(config.Sources["prometheus"].Headers) != 2 || config.Sources["prometheus"].Headers["X-Scope-OrgID"] != "tenant-a" {
+		t.Fatalf("expected the profile to be left unchanged, got %v", config.Sources["prometheus"].Headers)
+	}
+
+	t.Setenv("Q2GIT_CONFIG", "queries:\n  - name: x\n    source: missing\n")
+	if _, err := LoadConfig(); err == nil {
+		t.Fatalf("expected an unknown source to be rejected")
+	}
+}
+
+func TestFetchDataSendsMethodHeadersAuthAndBody(t *testing.T) {
+	wadge.RunTest(t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			user, pass, _ := r.BasicAuth()
+			if r.Method != http.MethodPost || string(body) != "query=power" || r.Header.Get("X-Scope-OrgID") != "tenant-b" ||
+				user != "prom-user" || pass != "prom-pass" {
+				http.Error(w, "unexpected request", http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"ok":true}`))
+		}))
+		defer server.Close()
+
+		data, err := FetchData(&SourceConfig{
+			Method:  http.MethodPost,
+			Headers: map[string]string{"X-Scope-OrgID": "tenant-b"},
+			Auth:    AuthConfig{Username: "prom-user", Password: "prom-pass"},
+			Body:    "query=power",
+		}, server.URL)
+		if err != nil {
+			t.Fatalf("FetchData failed: %s", err)
+		}
+		if string(data) != `{"ok":true}` {
+			t.Fatalf("unexpected response: %s", data)
+		}
+	})
+}
+
+func TestLoadConfigEncodesObjectBodiesAsJSON(t *testing.T) {
+	t.Setenv("Q2GIT_CONFIG", `
+queries:
+  - name: both
+    url: https://api.example.com/graphql
+    body: '{}'
+    graphql:
+      query: '{ viewer { login } }'
+    query: '.'
+`)
+	if _, err := LoadConfig(); err == nil {
+		t.Fatalf("expected body and graphql together to be rejected")
+	}
+
+	t.Setenv("Q2GIT_CONFIG", `
+queries:
+  - name: search
+    url: https://es.example.com/logs/_search
+    method: POST
+    body:
+      size: 0
+      query:
+        term:
+          level: error
+    query: '.hits.total.value'
+`)
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %s", err)
+	}
+	if got := string(config.Queries[0].Body); got != `{"query":{"term":{"level":"error"}},"size":0}` {
+		t.Fatalf("unexpected body: %s", got)
+	}
+}
+
+func TestFetchDataSendsGraphQLQueries(t *testing.T) {
+	wadge.RunTest(t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				Query     string                 `json:"query"`
+				Variables map[string]interface{} `json:"variables"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || r.Method != http.MethodPost ||
+				r.Header.Get("Content-Type") != "application/json" {
+				http.Error(w, "unexpected request", http.StatusBadRequest)
+				return
+			}
+			if req.Variables["owner"] != "q2git" {
+				_, _ = w.Write([]byte(`{"data":null,"errors":[{"message":"Variable $owner is required"},{"message":"Field 'x' doesn't exist"}]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"data":{"repository":{"stars":42}}}`))
+		}))
+		defer server.Close()
+
+		source := &SourceConfig{
+			Method: http.MethodGet,
+			GraphQL: &GraphQLConfig{
+				Query:     "query($owner: String!) { repository(owner: $owner) { stars } }",
+				Variables: map[string]interface{}{"owner": "q2git"},
+			},
+		}
+		data, err := FetchData(source, server.URL)
+		if err != nil {
+			t.Fatalf("FetchData failed: %s", err)
+		}
+		if string(data) != `{"data":{"repository":{"stars":42}}}` {
+			t.Fatalf("unexpected response: %s", data)
+		}
+
+		source.GraphQL.Variables = nil
+		_, err = FetchData(source, server.URL)
+		if err == nil || err.Error() != "GraphQL errors: Variable $owner is required; Field 'x' doesn't exist" {
+			t.Fatalf("expected the GraphQL errors to fail the query, got %v", err)
+		}
+	})
+}
@@ -1,86 +1,348 @@
 package main
 
 import (
-	"bytes"
 	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
-
-	"go.wasmcloud.dev/component/net/wasihttp"
 )
 
-func CommitToGit(cfg *DestinationConfig, settings *SettingsConfig, content []byte) error {
-	if cfg.Token == "" {
-		return fmt.Errorf("git token not configured")
+// githubCommitter writes to a GitHub repository through the Git Data API.
+type githubCommitter struct {
+	cfg *DestinationConfig
+	// createBranch is set when Head found the branch missing and started it
+	// from create_branch_from, so that Commit creates the ref.
+	createBranch bool
+	// commitTrees maps commit SHAs to their root tree SHA, and trees caches
+	// tree listings by tree SHA.
+	commitTrees map[string]string
+	trees       map[string]*gitTree
+}
+
+func newGitHubCommitter(cfg *DestinationConfig) *githubCommitter {
+	return &githubCommitter{
+		cfg:         cfg,
+		commitTrees: map[string]string{},
+		trees:       map[string]*gitTree{},
 	}
+}
 
-	baseSHA, err := getBranchRef(cfg)
+// Head returns the branch head. A missing branch starts at the head of
+// create_branch_from, if set, and an empty repository has no head at all.
+func (g *githubCommitter) Head() (string, error) {
+	g.createBranch = false
+	sha, err := getBranchRef(g.cfg)
+	switch {
+	case err == nil:
+		return sha, nil
+	case isEmptyRepository(err):
+		return "", nil
+	case !errors.Is(err, errNotFound):
+		return "", err
+	case g.cfg.CreateBranchFrom == "":
+		return "", fmt.Errorf("branch '%s' does not exist, set destination.create_branch_from to create it: %w", g.cfg.Branch, err)
+	}
+
+	from := *g.cfg
+	from.Branch = g.cfg.CreateBranchFrom
+	sha, err = getBranchRef(&from)
 	if err != nil {
-		return err
+		if isEmptyRepository(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("branch '%s' to create '%s' from: %w", from.Branch, g.cfg.Branch, err)
 	}
+	g.createBranch = true
+	return sha, nil
+}
 
-	treeSHA, err := getCommitTree(cfg, baseSHA)
+func (g *githubCommitter) Lookup(ref, path string) (*fileState, error) {
+	if ref == "" {
+		return nil, nil
+	}
+	treeSHA, err := g.commitTree(ref)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	entry, err := getTreeEntry(g.cfg, g.trees, treeSHA, path)
+	if err != nil || entry == nil {
+		return nil, err
 	}
+	return &fileState{Mode: entry.Mode, SHA: entry.SHA}, nil
+}
 
-	if settings.WriteMode == "append" {
-		existing, err := getFileContent(cfg)
-		if err == nil {
-			content = append(existing, content...)
+// ReadFile fetches the blob the commit's tree points at. Unlike the contents
+// API, which leaves content empty for files over 1MB, the blobs API serves
+// files up to 100MB.
+func (g *githubCommitter) ReadFile(ref, path string) ([]byte, error) {
+	state, err := g.Lookup(ref, path)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, fmt.Errorf("file '%s' not found at %s", path, ref)
+	}
+	content, err := getBlob(g.cfg, state.SHA)
+	if err != nil {
+		return nil, err
+	}
+	if obj, ok := parseLFSPointer(content); ok {
+		return downloadLFSObject(g.cfg, obj)
+	}
+	return content, nil
+}
+
+// Commit uploads a blob per change, builds one tree on top of the base tree
+// and fast-forwards the branch to the new commit.
+func (g *githubCommitter) Commit(base string, changes []stagedChange) (string, error) {
+	var objects [][]byte
+	for _, change := range changes {
+		if change.LFS && !change.Delete {
+			objects = append(objects, change.Content)
 		}
-		// If file doesn't exist yet, just use content as-is
+	}
+	if len(objects) > 0 {
+		if err := uploadLFSObjects(g.cfg, objects); err != nil {
+			return "", err
+		}
+	}
+
+	if base == "" {
+		return g.commitInitial(changes)
+	}
+
+	treeSHA, err := g.commitTree(base)
+	if err != nil {
+		return "", err
+	}
+
+	if len(changes) > 0 {
+		entries := make([]treeEntry, 0, len(changes))
+		for _, change := range changes {
+			entry := treeEntry{Path: change.Path, Mode: fileMode(change.FileChange), Delete: change.Delete}
+			if !change.Delete {
+				entry.SHA, err = createBlob(g.cfg, blobContent(change.FileChange))
+				if err != nil {
+					return "", err
+				}
+			}
+			entries = append(entries, entry)
+		}
+
+		treeSHA, err = createTree(g.cfg, treeSHA, entries)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	commitSHA, err := createCommit(g.cfg, treeSHA, base)
+	if err != nil {
+		return "", err
 	}
 
-	blobSHA, err := createBlob(cfg, content)
+	if g.createBranch {
+		err = createBranchRef(g.cfg, commitSHA)
+	} else {
+		err = updateBranchRef(g.cfg, commitSHA)
+	}
 	if err != nil {
-		return err
+		return "", err
 	}
+	return commitSHA, nil
+}
 
-	newTreeSHA, err := createTree(cfg, treeSHA, blobSHA)
+// commitInitial makes the first commit of an empty repository. GitHub stores
+// no git objects until a repository has a commit, so the first file goes
+// through the contents API, which creates one. The commit holding all changes
+// is then built on top of that bootstrap commit and fast-forwarded to, so a
+// writer that got in between is not overwritten. The contents API cannot take
+// a signature, so signed destinations are refused rather than given an
+// unsigned root commit.
+func (g *githubCommitter) commitInitial(changes []stagedChange) (string, error) {
+	if g.cfg.Signing != "" {
+		return "", fmt.Errorf("cannot make a signed first commit in an empty repository: GitHub's contents API creates it unsigned, push an initial commit first")
+	}
+	var first *stagedChange
+	for i := range changes {
+		if !changes[i].Delete {
+			first = &changes[i]
+			break
+		}
+	}
+	if first == nil {
+		return "", fmt.Errorf("the first commit of an empty repository needs at least one file")
+	}
+	parentSHA, err := putInitialFile(g.cfg, first.Path, blobContent(first.FileChange))
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	entries := make([]treeEntry, 0, len(changes))
+	for _, change := range changes {
+		if change.Delete {
+			continue
+		}
+		sha, err := createBlob(g.cfg, blobContent(change.FileChange))
+		if err != nil {
+			return "", err
+		}
+		entries = append(entries, treeEntry{Path: change.Path, Mode: fileMode(change.FileChange), SHA: sha})
 	}
 
-	commitSHA, err := createCommit(cfg, newTreeSHA, baseSHA)
+	treeSHA, err := createTree(g.cfg, "", entries)
 	if err != nil {
-		return err
+		return "", err
+	}
+	commitSHA, err := createCommit(g.cfg, treeSHA, parentSHA)
+	if err != nil {
+		return "", err
+	}
+	if err := updateBranchRef(g.cfg, commitSHA); err != nil {
+		return "", err
 	}
+	return commitSHA, nil
+}
 
-	return updateBranchRef(cfg, commitSHA)
+func (g *githubCommitter) CommitURL(sha string) string {
+	return fmt.Sprintf("%s/%s/%s/commit/%s", githubWebURL(g.cfg), g.cfg.Owner, g.cfg.Repo, sha)
 }
 
-func getFileContent(cfg *DestinationConfig) ([]byte, error) {
-	url := fmt.Sprintf("%s/repos/%s/%s/contents/%s?ref=%s",
-		cfg.APIURL, cfg.Owner, cfg.Repo, cfg.OutputPath, cfg.Branch)
+// githubWebURL returns the web root of github.com or a GitHub Enterprise
+// Server instance.
+func githubWebURL(cfg *DestinationConfig) string {
+	web := webURL(cfg.APIURL, "/api/v3")
+	if web == "https://api.github.com" {
+		web = "https://github.com"
+	}
+	return web
+}
 
-	var fileData struct {
+func (g *githubCommitter) commitTree(commitSHA string) (string, error) {
+	if treeSHA, ok := g.commitTrees[commitSHA]; ok {
+		return treeSHA, nil
+	}
+	treeSHA, err := getCommitTree(g.cfg, commitSHA)
+	if err != nil {
+		return "", err
+	}
+	g.commitTrees[commitSHA] = treeSHA
+	return treeSHA, nil
+}
+
+// treeEntry is a change resolved to a blob; SHA is empty for deletions.
+type treeEntry struct {
+	Path   string
+	Mode   string
+	SHA    string
+	Delete bool
+}
+
+func getBlob(cfg *DestinationConfig, sha string) ([]byte, error) {
+	url := fmt.Sprintf("%s/repos/%s/%s/git/blobs/%s",
+		cfg.APIURL, cfg.Owner, cfg.Repo, sha)
+
+	var blobData struct {
 		Content  string `json:"content"`
 		Encoding string `json:"encoding"`
+		Size     int    `json:"size"`
 	}
 
-	if err := githubAPIRequest("GET", url, cfg.Token, nil, &fileData); err != nil {
-		return nil, err
+	if err := githubAPIRequest("GET", url, cfg, nil, &blobData); err != nil {
+		return nil, fmt.Errorf("failed to get blob: %w", err)
 	}
 
-	if fileData.Encoding != "base64" {
-		return nil, fmt.Errorf("unexpected encoding: %s", fileData.Encoding)
+	if blobData.Encoding != "base64" {
+		return nil, fmt.Errorf("unexpected encoding: %s", blobData.Encoding)
 	}
 
 	// GitHub returns base64 with newlines, strip them
-	clean := strings.ReplaceAll(fileData.Content, "\n", "")
+	clean := strings.ReplaceAll(blobData.Content, "\n", "")
 	decoded, err := base64.StdEncoding.DecodeString(clean)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode file content: %w", err)
+		return nil, fmt.Errorf("failed to decode blob content: %w", err)
+	}
+	if len(decoded) != blobData.Size {
+		return nil, fmt.Errorf("blob %s is %d bytes but only %d were returned", sha, blobData.Size, len(decoded))
 	}
 
 	return decoded, nil
 }
 
+type gitTreeItem struct {
+	Path string `json:"path"`
+	Mode string `json:"mode"`
+	Type string `json:"type"`
+	SHA  string `json:"sha"`
+}
+
+// gitTree is one directory level of a tree. Truncated is set when GitHub
+// left out entries because the directory is too large to list.
+type gitTree struct {
+	Items     []gitTreeItem `json:"tree"`
+	Truncated bool          `json:"truncated"`
+}
+
+// getTreeEntry resolves path inside the tree treeSHA, one directory level per
+// request, and returns nil if the path does not exist as a file. Fetched trees
+// are memoised in trees. A path missing from a truncated listing is an error,
+// as treating it as absent would overwrite the file.
+func getTreeEntry(cfg *DestinationConfig, trees map[string]*gitTree, treeSHA, path string) (*treeEntry, error) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	sha := treeSHA
+	for i, segment := range segments {
+		tree, ok := trees[sha]
+		if !ok {
+			var err error
+			tree, err = getTree(cfg, sha)
+			if err != nil {
+				return nil, err
+			}
+			trees[sha] = tree
+		}
+
+		var found *gitTreeItem
+		for j := range tree.Items {
+			if tree.Items[j].Path == segment {
+				found = &tree.Items[j]
+				break
+			}
+		}
+		if found == nil {
+			if tree.Truncated {
+				dir := "/" + strings.Join(segments[:i], "/")
+				return nil, fmt.Errorf("directory '%s' is too large for the trees API to tell whether '%s' exists", dir, path)
+			}
+			return nil, nil
+		}
+		if i == len(segments)-1 {
+			if found.Type != "blob" {
+				return nil, fmt.Errorf("path '%s' exists as a %s", path, found.Type)
+			}
+			return &treeEntry{Path: path, Mode: found.Mode, SHA: found.SHA}, nil
+		}
+		if found.Type != "tree" {
+			return nil, nil
+		}
+		sha = found.SHA
+	}
+	return nil, nil
+}
+
+func getTree(cfg *DestinationConfig, treeSHA string) (*gitTree, error) {
+	url := fmt.Sprintf("%s/repos/%s/%s/git/trees/%s",
+		cfg.APIURL, cfg.Owner, cfg.Repo, treeSHA)
+
+	var tree gitTree
+	if err := githubAPIRequest("GET", url, cfg, nil, &tree); err != nil {
+		return nil, fmt.Errorf("failed to get tree: %w", err)
+	}
+
+	return &tree, nil
+}
+
 func getBranchRef(cfg *DestinationConfig) (string, error) {
 	url := fmt.Sprintf("%s/repos/%s/%s/git/refs/heads/%s",
 		cfg.APIURL, cfg.Owner, cfg.Repo, cfg.Branch)
@@ -91,7 +353,7 @@ func getBranchRef(cfg *DestinationConfig) (string, error) {
 		} `json:"object"`
 	}
 
-	if err := githubAPIRequest("GET", url, cfg.Token, nil, &refData); err != nil {
+	if err := githubAPIRequest("GET", url, cfg, nil, &refData); err != nil {
 		return "", fmt.Errorf("failed to get branch ref: %w", err)
 	}
 
@@ -108,7 +370,7 @@ func getCommitTree(cfg *DestinationConfig, commitSHA string) (string, error) {
 		} `json:"tree"`
 	}
 
-	if err := githubAPIRequest("GET", url, cfg.Token, nil, &commitData); err != nil {
+	if err := githubAPIRequest("GET", url, cfg, nil, &commitData); err != nil {
 		return "", fmt.Errorf("failed to get commit tree: %w", err)
 	}
 
@@ -128,57 +390,97 @@ func createBlob(cfg *DestinationConfig, content []byte) (string, error) {
 		SHA string `json:"sha"`
 	}
 
-	if err := githubAPIRequest("POST", url, cfg.Token, payload, &blobData); err != nil {
+	if err := githubAPIRequest("POST", url, cfg, payload, &blobData); err != nil {
 		return "", fmt.Errorf("failed to create blob: %w", err)
 	}
 
 	return blobData.SHA, nil
 }
 
-func createTree(cfg *DestinationConfig, baseTreeSHA, blobSHA string) (string, error) {
+// createTree builds a tree on top of baseTreeSHA with one entry per change.
+// Deleted paths are sent with a null SHA, which removes them from the tree.
+func createTree(cfg *DestinationConfig, baseTreeSHA string, entries []treeEntry) (string, error) {
 	url := fmt.Sprintf("%s/repos/%s/%s/git/trees",
 		cfg.APIURL, cfg.Owner, cfg.Repo)
 
-	payload := map[string]interface{}{
-		"base_tree": baseTreeSHA,
-		"tree": []map[string]string{
-			{
-				"path": cfg.OutputPath,
-				"mode": "100644",
-				"type": "blob",
-				"sha":  blobSHA,
-			},
-		},
+	sorted := append([]treeEntry(nil), entries...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+
+	tree := make([]map[string]interface{}, 0, len(sorted))
+	for _, entry := range sorted {
+		item := map[string]interface{}{
+			"path": strings.Trim(entry.Path, "/"),
+			"mode": entry.Mode,
+			"type": "blob",
+			"sha":  entry.SHA,
+		}
+		if entry.Delete {
+			item["sha"] = nil
+		}
+		tree = append(tree, item)
+	}
+
+	payload := map[string]interface{}{"tree": tree}
+	if baseTreeSHA != "" {
+		payload["base_tree"] = baseTreeSHA
 	}
 
 	var treeData struct {
 		SHA string `json:"sha"`
 	}
 
-	if err := githubAPIRequest("POST", url, cfg.Token, payload, &treeData); err != nil {
+	if err := githubAPIRequest("POST", url, cfg, payload, &treeData); err != nil {
 		return "", fmt.Errorf("failed to create tree: %w", err)
 	}
 
 	return treeData.SHA, nil
 }
 
+// createCommit creates a commit of treeSHA on top of parentSHA, or a root
+// commit if parentSHA is empty.
 func createCommit(cfg *DestinationConfig, treeSHA, parentSHA string) (string, error) {
 	url := fmt.Sprintf("%s/repos/%s/%s/git/commits",
 		cfg.APIURL, cfg.Owner, cfg.Repo)
 
-	message := strings.ReplaceAll(cfg.CommitMessage, "{{.Timestamp}}", time.Now().Format(time.RFC3339))
+	now := time.Now()
+	author, committer, err := commitIdentities(cfg, now)
+	if err != nil {
+		return "", err
+	}
+
+	parents := []string{}
+	if parentSHA != "" {
+		parents = append(parents, parentSHA)
+	}
 
 	payload := map[string]interface{}{
-		"message": message,
+		"message": commitMessage(cfg),
 		"tree":    treeSHA,
-		"parents": []string{parentSHA},
+		"parents": parents,
+	}
+	if author != nil {
+		payload["author"] = author.apiFields()
+		payload["committer"] = committer.apiFields()
+	}
+	if cfg.Signing != "" {
+		// GitHub verifies the signature against the commit object it builds
+		// from the fields above, so both sides must agree byte for byte.
+		object, err := commitObject(treeSHA, parents, author, committer, commitMessage(cfg))
+		if err != nil {
+			return "", fmt.Errorf("failed to sign commit: %w", err)
+		}
+		signature, err := signCommit(cfg.Signing, cfg.SigningKey, object, now)
+		if err != nil {
+			return "", fmt.Errorf("failed to sign commit: %w", err)
+		}
+		payload["signature"] = signature
 	}
 
 	var commitData struct {
 		SHA string `json:"sha"`
 	}
 
-	if err := githubAPIRequest("POST", url, cfg.Token, payload, &commitData); err != nil {
+	if err := githubAPIRequest("POST", url, cfg, payload, &commitData); err != nil {
 		return "", fmt.Errorf("failed to create commit: %w", err)
 	}
 
@@ -186,64 +488,147 @@ func createCommit(cfg *DestinationConfig, treeSHA, parentSHA string) (string, er
 }
 
 func updateBranchRef(cfg *DestinationConfig, commitSHA string) error {
+	return setBranchRef(cfg, commitSHA, false)
+}
+
+func setBranchRef(cfg *DestinationConfig, commitSHA string, force bool) error {
 	url := fmt.Sprintf("%s/repos/%s/%s/git/refs/heads/%s",
 		cfg.APIURL, cfg.Owner, cfg.Repo, cfg.Branch)
 
 	payload := map[string]interface{}{
 		"sha":   commitSHA,
-		"force": false,
+		"force": force,
 	}
 
-	if err := githubAPIRequest("PATCH", url, cfg.Token, payload, nil); err != nil {
+	if err := githubAPIRequest("PATCH", url, cfg, payload, nil); err != nil {
+		// GitHub answers 422 "Update is not a fast forward" when the
+		// branch no longer points at our parent commit. Other 422s, such as
+		// a missing ref or object, are not fixed by retrying.
+		var apiErr *apiError
+		if !force && errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnprocessableEntity &&
+			strings.Contains(apiErr.Body, "not a fast forward") {
+			return fmt.Errorf("%w: %s", errBranchMoved, apiErr.Body)
+		}
 		return fmt.Errorf("failed to update branch ref: %w", err)
 	}
 
 	return nil
 }
 
-func githubAPIRequest(method, url, token string, payload, response interface{}) error {
-	var body io.Reader
-	if payload != nil {
-		jsonData, err := json.Marshal(payload)
-		if err != nil {
-			return err
+func createBranchRef(cfg *DestinationConfig, commitSHA string) error {
+	url := fmt.Sprintf("%s/repos/%s/%s/git/refs",
+		cfg.APIURL, cfg.Owner, cfg.Repo)
+
+	payload := map[string]string{
+		"ref": "refs/heads/" + cfg.Branch,
+		"sha": commitSHA,
+	}
+
+	if err := githubAPIRequest("POST", url, cfg, payload, nil); err != nil {
+		// 422 "Reference already exists": another writer created the
+		// branch first.
+		var apiErr *apiError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnprocessableEntity {
+			return fmt.Errorf("%w: %s", errBranchMoved, apiErr.Body)
 		}
-		body = bytes.NewBuffer(jsonData)
+		return fmt.Errorf("failed to create branch ref: %w", err)
 	}
 
-	req, err := http.NewRequest(method, url, body)
+	return nil
+}
+
+func deleteBranchRef(cfg *DestinationConfig) error {
+	url := fmt.Sprintf("%s/repos/%s/%s/git/refs/heads/%s",
+		cfg.APIURL, cfg.Owner, cfg.Repo, cfg.Branch)
+
+	if err := githubAPIRequest("DELETE", url, cfg, nil, nil); err != nil {
+		return fmt.Errorf("failed to delete branch ref: %w", err)
+	}
+
+	return nil
+}
+
+// putInitialFile writes path through the contents API, which unlike the Git
+// Data API works in an empty repository, and returns the first commit it
+// creates.
+func putInitialFile(cfg *DestinationConfig, path string, content []byte) (string, error) {
+	url := fmt.Sprintf("%s/repos/%s/%s/contents/%s",
+		cfg.APIURL, cfg.Owner, cfg.Repo, strings.Trim(path, "/"))
+
+	author, committer, err := commitIdentities(cfg, time.Now())
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Accept", "application/vnd.github+json")
-	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
-	if payload != nil {
-		req.Header.Set("Content-Type", "application/json")
+	payload := map[string]interface{}{
+		"message": commitMessage(cfg),
+		"content": base64.StdEncoding.EncodeToString(content),
+		"branch":  cfg.Branch,
+	}
+	if author != nil {
+		payload["author"] = author.apiFields()
+		payload["committer"] = committer.apiFields()
 	}
 
-	client := &http.Client{
-		Transport: &wasihttp.Transport{
-			ConnectTimeout: 30 * time.Second,
-		},
+	var contentData struct {
+		Commit struct {
+			SHA string `json:"sha"`
+		} `json:"commit"`
 	}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+	if err := githubAPIRequest("PUT", url, cfg, payload, &contentData); err != nil {
+		// 422 "sha wasn't supplied": the file, and so a first commit, was
+		// created by another writer in the meantime.
+		var apiErr *apiError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnprocessableEntity {
+			return "", fmt.Errorf("%w: %s", errBranchMoved, apiErr.Body)
+		}
+		return "", fmt.Errorf("failed to create initial commit: %w", err)
 	}
-	defer resp.Body.Close()
 
-	respBody, _ := io.ReadAll(resp.Body)
+	return contentData.Commit.SHA, nil
+}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(respBody))
-	}
+// isEmptyRepository reports whether err is GitHub's 409 "Git Repository is
+// empty." answer, given for refs and objects before the first commit.
+func isEmptyRepository(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict &&
+		strings.Contains(apiErr.Body, "Git Repository is empty")
+}
 
-	if response != nil {
-		return json.Unmarshal(respBody, response)
+// githubAPIRequest calls the GitHub REST or GraphQL API with the
+// destination's token or GitHub App installation token. Rate limited calls,
+// and failed (5xx) calls of idempotent methods, are retried while the total
+// wait stays within maxGitHubRetryWait; see githubRetryDelay.
+func githubAPIRequest(method, url string, cfg *DestinationConfig, payload, response interface{}) error {
+	var waited time.Duration
+	for attempt := 0; ; attempt++ {
+		token, err := githubToken(cfg)
+		if err != nil {
+			return err
+		}
+		err = jsonAPIRequest(method, url, githubHeaders(token), payload, response)
+		if err == nil {
+			return nil
+		}
+
+		delay, retry := githubRetryDelay(method, err, attempt, time.Now())
+		if !retry || attempt+1 >= maxGitHubAttempts {
+			return err
+		}
+		if waited+delay > maxGitHubRetryWait {
+			return fmt.Errorf("not waiting another %s to retry: %w", delay.Round(time.Second), err)
+		}
+		time.Sleep(delay)
+		waited += delay
 	}
+}
 
-	return nil
+func githubHeaders(token string) map[string]string {
+	return map[string]string{
+		"Authorization":        "Bearer " + token,
+		"Accept":               "application/vnd.github+json",
+		"X-GitHub-Api-Version": "2022-11-28",
+	}
 }
@@ -0,0 +1,792 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.wasmcloud.dev/wadge"
+	"golang.org/x/crypto/ssh"
+)
+
+type fakeTreeEntry struct {
+	Mode string
+	SHA  string
+}
+
+type fakeCommit struct {
+	Tree      string
+	Parents   []string
+	Message   string
+	Author    map[string]interface{}
+	Committer map[string]interface{}
+	Signature string
+}
+
+// fakeGitHub is an in-memory stand-in for the GitHub Git Data and contents
+// APIs of a single repository.
+type fakeGitHub struct {
+	mu      sync.Mutex
+	refs    map[string]string
+	commits map[string]fakeCommit
+	trees   map[string]map[string]fakeTreeEntry
+	blobs   map[string][]byte
+	counter int
+
+	// beforeUpdate runs before a ref update is applied, e.g. to simulate a
+	// concurrent push.
+	beforeUpdate func(f *fakeGitHub)
+}
+
+// newFakeGitHub creates a repository whose main branch holds files, or an
+// empty repository without commits if files is nil.
+func newFakeGitHub(files map[string]string) *fakeGitHub {
+	f := &fakeGitHub{
+		refs:    map[string]string{},
+		commits: map[string]fakeCommit{},
+		trees:   map[string]map[string]fakeTreeEntry{},
+		blobs:   map[string][]byte{},
+	}
+	if files != nil {
+		f.refs["main"] = f.commitFiles("", files)
+	}
+	return f
+}
+
+func (f *fakeGitHub) nextSHA() string {
+	f.counter++
+	return fmt.Sprintf("%040x", f.counter)
+}
+
+func (f *fakeGitHub) putBlob(content []byte) string {
+	sum := sha1.Sum(append([]byte(fmt.Sprintf("blob %d\x00", len(content))), content...))
+	sha := hex.EncodeToString(sum[:])
+	f.blobs[sha] = content
+	return sha
+}
+
+// commitFiles records a commit on top of parent that sets the given files.
+func (f *fakeGitHub) commitFiles(parent string, files map[string]string) string {
+	tree := map[string]fakeTreeEntry{}
+	if parent != "" {
+		for path, entry := range f.trees[f.commits[parent].Tree] {
+			tree[path] = entry
+		}
+	}
+	for path, content := range files {
+		tree[path] = fakeTreeEntry{Mode: FileModeRegular, SHA: f.putBlob([]byte(content))}
+	}
+	treeSHA := f.nextSHA()
+	f.trees[treeSHA] = tree
+
+	commitSHA := f.nextSHA()
+	var parents []string
+	if parent != "" {
+		parents = []string{parent}
+	}
+	f.commits[commitSHA] = fakeCommit{Tree: treeSHA, Parents: parents}
+	return commitSHA
+}
+
+// file returns the content of path at the head of branch.
+func (f *fakeGitHub) file(branch, path string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entry, ok := f.trees[f.commits[f.refs[branch]].Tree][path]
+	if !ok {
+		return "", false
+	}
+	return string(f.blobs[entry.SHA]), true
+}
+
+// ancestors returns sha and every commit reachable from it.
+func (f *fakeGitHub) ancestors(sha string) map[string]bool {
+	seen := map[string]bool{}
+	for pending := []string{sha}; len(pending) > 0; pending = pending[1:] {
+		if !seen[pending[0]] {
+			seen[pending[0]] = true
+			pending = append(pending, f.commits[pending[0]].Parents...)
+		}
+	}
+	return seen
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	const prefix = "/repos/owner/repo/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, prefix)
+
+	var body map[string]interface{}
+	if r.Body != nil {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	if len(f.refs) == 0 && strings.HasPrefix(path, "git/") {
+		http.Error(w, `{"message":"Git Repository is empty."}`, http.StatusConflict)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "git/refs/heads/"):
+		sha, ok := f.refs[strings.TrimPrefix(path, "git/refs/heads/")]
+		if !ok {
+			http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+			return
+		}
+		writeFakeJSON(w, map[string]interface{}{"object": map[string]string{"sha": sha}})
+
+	case r.Method == http.MethodPatch && strings.HasPrefix(path, "git/refs/heads/"):
+		if f.beforeUpdate != nil {
+			f.beforeUpdate(f)
+		}
+		branch := strings.TrimPrefix(path, "git/refs/heads/")
+		sha := body["sha"].(string)
+		parents := f.commits[sha].Parents
+		if force, _ := body["force"].(bool); !force && (len(parents) != 1 || parents[0] != f.refs[branch]) {
+			http.Error(w, `{"message":"Update is not a fast forward"}`, http.StatusUnprocessableEntity)
+			return
+		}
+		f.refs[branch] = sha
+		writeFakeJSON(w, map[string]interface{}{"object": map[string]string{"sha": sha}})
+
+	case r.Method == http.MethodDelete && strings.HasPrefix(path, "git/refs/heads/"):
+		branch := strings.TrimPrefix(path, "git/refs/heads/")
+		if _, ok := f.refs[branch]; !ok {
+			http.Error(w, `{"message":"Reference does not exist"}`, http.StatusUnprocessableEntity)
+			return
+		}
+		delete(f.refs, branch)
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPost && path == "git/refs":
+		branch := strings.TrimPrefix(body["ref"].(string), "refs/heads/")
+		if _, ok := f.refs[branch]; ok {
+			http.Error(w, `{"message":"Reference already exists"}`, http.StatusUnprocessableEntity)
+			return
+		}
+		f.refs[branch] = body["sha"].(string)
+		writeFakeJSON(w, map[string]interface{}{"object": map[string]string{"sha": f.refs[branch]}})
+
+	case r.Method == http.MethodPut && strings.HasPrefix(path, "contents/"):
+		branch := body["branch"].(string)
+		if _, ok := f.refs[branch]; ok {
+			http.Error(w, `{"message":"Invalid request. \"sha\" wasn't supplied."}`, http.StatusUnprocessableEntity)
+			return
+		}
+		content, _ := base64.StdEncoding.DecodeString(body["content"].(string))
+		f.refs[branch] = f.commitFiles("", map[string]string{strings.TrimPrefix(path, "contents/"): string(content)})
+		commit := f.commits[f.refs[branch]]
+		commit.Author, _ = body["author"].(map[string]interface{})
+		commit.Committer, _ = body["committer"].(map[string]interface{})
+		f.commits[f.refs[branch]] = commit
+		writeFakeJSON(w, map[string]interface{}{"commit": map[string]string{"sha": f.refs[branch]}})
+
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "git/commits/"):
+		commit, ok := f.commits[strings.TrimPrefix(path, "git/commits/")]
+		if !ok {
+			http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+			return
+		}
+		writeFakeJSON(w, map[string]interface{}{"tree": map[string]string{"sha": commit.Tree}})
+
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "git/trees/"):
+		// Trees are stored flat; directories are addressed as "<tree>:<dir>/".
+		id := strings.TrimPrefix(path, "git/trees/")
+		treeSHA, dir, _ := strings.Cut(id, ":")
+		seen := map[string]bool{}
+		var items []map[string]string
+		for p, entry := range f.trees[treeSHA] {
+			if !strings.HasPrefix(p, dir) {
+				continue
+			}
+			name, rest, isDir := strings.Cut(strings.TrimPrefix(p, dir), "/")
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			if isDir && rest != "" {
+				items = append(items, map[string]string{"path": name, "mode": "040000", "type": "tree", "sha": treeSHA + ":" + dir + name + "/"})
+				continue
+			}
+			items = append(items, map[string]string{"path": name, "mode": entry.Mode, "type": "blob", "sha": entry.SHA})
+		}
+		writeFakeJSON(w, map[string]interface{}{"tree": items})
+
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "compare/"):
+		base, head, _ := strings.Cut(strings.TrimPrefix(path, "compare/"), "...")
+		onBase := f.ancestors(base)
+		ahead := 0
+		for sha := range f.ancestors(head) {
+			if !onBase[sha] {
+				ahead++
+			}
+		}
+		writeFakeJSON(w, map[string]interface{}{"ahead_by": ahead})
+
+	case r.Method == http.MethodPost && path == "git/blobs":
+		content, _ := base64.StdEncoding.DecodeString(body["content"].(string))
+		writeFakeJSON(w, map[string]string{"sha": f.putBlob(content)})
+
+	case r.Method == http.MethodPost && path == "git/trees":
+		tree := map[string]fakeTreeEntry{}
+		baseTree, _ := body["base_tree"].(string)
+		for p, entry := range f.trees[baseTree] {
+			tree[p] = entry
+		}
+		for _, raw := range body["tree"].([]interface{}) {
+			item := raw.(map[string]interface{})
+			p := item["path"].(string)
+			if item["sha"] == nil {
+				delete(tree, p)
+				continue
+			}
+			tree[p] = fakeTreeEntry{Mode: item["mode"].(string), SHA: item["sha"].(string)}
+		}
+		sha := f.nextSHA()
+		f.trees[sha] = tree
+		writeFakeJSON(w, map[string]string{"sha": sha})
+
+	case r.Method == http.MethodPost && path == "git/commits":
+		var parents []string
+		for _, p := range body["parents"].([]interface{}) {
+			parents = append(parents, p.(string))
+		}
+		sha := f.nextSHA()
+		commit := fakeCommit{Tree: body["tree"].(string), Parents: parents, Message: body["message"].(string)}
+		commit.Author, _ = body["author"].(map[string]interface{})
+		commit.Committer, _ = body["committer"].(map[string]interface{})
+		commit.Signature, _ = body["signature"].(string)
+		f.commits[sha] = commit
+		writeFakeJSON(w, map[string]string{"sha": sha})
+
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "git/blobs/"):
+		content, ok := f.blobs[strings.TrimPrefix(path, "git/blobs/")]
+		if !ok {
+			http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+			return
+		}
+		writeFakeJSON(w, map[string]interface{}{
+			"encoding": "base64",
+			"content":  base64.StdEncoding.EncodeToString(content),
+			"size":     len(content),
+		})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeFakeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func newTestDestination(url string) *DestinationConfig {
+	return &DestinationConfig{
+		APIURL:        url,
+		Owner:         "owner",
+		Repo:          "repo",
+		Branch:        "main",
+		CommitMessage: "update",
+		Token:         "token",
+	}
+}
+
+func TestCommitToGitWritesAllChangesInOneCommit(t *testing.T) {
+	wadge.RunTest(t, func() {
+		fake := newFakeGitHub(map[string]string{"data.csv": "a\n", "old.txt": "x"})
+		server := httptest.NewServer(fake)
+		defer server.Close()
+
+		before := fake.refs["main"]
+		result, err := CommitToGit(newGitHubCommitter(newTestDestination(server.URL)), &SettingsConfig{}, []FileChange{
+			{Path: "data.csv", Content: []byte("b\n"), WriteMode: WriteModeAppend},
+			{Path: "run.sh", Content: []byte("#!/bin/sh\n"), Mode: FileModeExecutable},
+			{Path: "old.txt", Delete: true},
+		})
+		if err != nil {
+			t.Fatalf("CommitToGit failed: %s", err)
+		}
+
+		if got := fake.commits[result.SHA].Parents; len(got) != 1 || got[0] != before {
+			t.Fatalf("expected a single commit on top of %s, got parents %v", before, got)
+		}
+		if result.ParentSHA != before {
+			t.Fatalf("unexpected parent SHA in result: %s", result.ParentSHA)
+		}
+		if want := server.URL + "/owner/repo/commit/" + result.SHA; result.URL != want {
+			t.Fatalf("unexpected commit URL: want %s, got %s", want, result.URL)
+		}
+		for i, want := range []FileResult{
+			{Path: "data.csv", Bytes: 4, WriteMode: "append", Status: "changed"},
+			{Path: "run.sh", Bytes: 10, WriteMode: "overwrite", Status: "changed"},
+			{Path: "old.txt", WriteMode: "delete", Status: "changed"},
+		} {
+			if result.Files[i] != want {
+				t.Fatalf("unexpected file result: want %+v, got %+v", want, result.Files[i])
+			}
+		}
+		if got, _ := fake.file("main", "data.csv"); got != "a\nb\n" {
+			t.Fatalf("unexpected data.csv content: %q", got)
+		}
+		if got := fake.trees[fake.commits[result.SHA].Tree]["run.sh"].Mode; got != FileModeExecutable {
+			t.Fatalf("unexpected run.sh mode: %s", got)
+		}
+		if _, ok := fake.file("main", "old.txt"); ok {
+			t.Fatalf("expected old.txt to be deleted")
+		}
+	})
+}
+
+func TestCommitToGitLeavesBranchUntouchedWhenAChangeFails(t *testing.T) {
+	wadge.RunTest(t, func() {
+		fake := newFakeGitHub(map[string]string{"a.txt": "a", "b.txt": "b"})
+		blobs := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost && r.URL.Path == "/repos/owner/repo/git/blobs" {
+				if blobs++; blobs == 2 {
+					http.Error(w, `{"message":"content is too large"}`, http.StatusUnprocessableEntity)
+					return
+				}
+			}
+			fake.ServeHTTP(w, r)
+		}))
+		defer server.Close()
+
+		before := fake.refs["main"]
+		commits := len(fake.commits)
+		_, err := CommitToGit(newGitHubCommitter(newTestDestination(server.URL)), &SettingsConfig{}, []FileChange{
+			{Path: "a.txt", Content: []byte("new a")},
+			{Path: "b.txt", Content: []byte("new b")},
+		})
+		if err == nil {
+			t.Fatalf("expected the failing change to fail the commit")
+		}
+		if fake.refs["main"] != before || len(fake.commits) != commits {
+			t.Fatalf("expected no commit when one of the changes fails")
+		}
+		if got, _ := fake.file("main", "a.txt"); got != "a" {
+			t.Fatalf("expected a.txt to be left alone, got %q", got)
+		}
+	})
+}
+
+func TestCommitToGitRetriesWhenBranchMoved(t *testing.T) {
+	wadge.RunTest(t, func() {
+		fake := newFakeGitHub(map[string]string{"data.csv": "a\n"})
+		fake.beforeUpdate = func(f *fakeGitHub) {
+			f.refs["main"] = f.commitFiles(f.refs["main"], map[string]string{"data.csv": "a\nconcurrent\n"})
+			f.beforeUpdate = nil
+		}
+		server := httptest.NewServer(fake)
+		defer server.Close()
+
+		result, err := CommitToGit(newGitHubCommitter(newTestDestination(server.URL)), &SettingsConfig{}, []FileChange{
+			{Path: "data.csv", Content: []byte("b\n"), WriteMode: WriteModeAppend},
+		})
+		if err != nil {
+			t.Fatalf("CommitToGit failed: %s", err)
+		}
+		if result.Retries != 1 {
+			t.Fatalf("expected 1 retry, got %d", result.Retries)
+		}
+		if got, _ := fake.file("main", "data.csv"); got != "a\nconcurrent\nb\n" {
+			t.Fatalf("append was not re-applied on the new head: %q", got)
+		}
+	})
+}
+
+func TestCommitToGitRetriesOnlyNonFastForwardRefUpdates(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		body    string
+		retried bool
+	}{
+		{"not a fast forward", `{"message":"Update is not a fast forward"}`, true},
+		{"missing object", `{"message":"Object does not exist"}`, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			wadge.RunTest(t, func() {
+				fake := newFakeGitHub(map[string]string{"data.csv": "a\n"})
+				updates := 0
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, "/repos/owner/repo/git/refs/heads/") {
+						if updates++; updates == 1 {
+							http.Error(w, tt.body, http.StatusUnprocessableEntity)
+							return
+						}
+					}
+					fake.ServeHTTP(w, r)
+				}))
+				defer server.Close()
+
+				result, err := CommitToGit(newGitHubCommitter(newTestDestination(server.URL)), &SettingsConfig{}, []FileChange{
+					{Path: "data.csv", Content: []byte("b\n")},
+				})
+				if tt.retried {
+					if err != nil || result.Retries != 1 {
+						t.Fatalf("expected one retry, got %v, %+v", err, result)
+					}
+					return
+				}
+				if err == nil || errors.Is(err, errBranchMoved) || updates != 1 {
+					t.Fatalf("expected the ref update to fail without a retry, got %v after %d updates", err, updates)
+				}
+			})
+		})
+	}
+}
+
+func TestCommitToGitSkipsUnchangedContent(t *testing.T) {
+	wadge.RunTest(t, func() {
+		fake := newFakeGitHub(map[string]string{"data/out.json": "{}"})
+		server := httptest.NewServer(fake)
+		defer server.Close()
+
+		before := fake.refs["main"]
+		changes := []FileChange{{Path: "data/out.json", Content: []byte("{}")}}
+
+		result, err := CommitToGit(newGitHubCommitter(newTestDestination(server.URL)), &SettingsConfig{}, changes)
+		if err != nil {
+			t.Fatalf("CommitToGit failed: %s", err)
+		}
+		if !result.Unchanged || fake.refs["main"] != before {
+			t.Fatalf("expected no commit for identical content")
+		}
+
+		result, err = CommitToGit(newGitHubCommitter(newTestDestination(server.URL)), &SettingsConfig{AllowEmptyCommits: true}, changes)
+		if err != nil {
+			t.Fatalf("CommitToGit failed: %s", err)
+		}
+		if result.Unchanged || fake.refs["main"] == before {
+			t.Fatalf("expected a heartbeat commit when empty commits are allowed")
+		}
+	})
+}
+
+func TestPreviewCommitDiffsWithoutWriting(t *testing.T) {
+	wadge.RunTest(t, func() {
+		fake := newFakeGitHub(map[string]string{
+			"data.csv": "a\nb\nc\nd\ne\nf\n",
+			"same.txt": "same\n",
+		})
+		before := fake.refs["main"]
+		blobs := len(fake.blobs)
+		reads := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/repos/owner/repo/git/blobs/") {
+				reads++
+			}
+			fake.ServeHTTP(w, r)
+		}))
+		defer server.Close()
+
+		preview, err := PreviewCommit(newGitHubCommitter(newTestDestination(server.URL)), []FileChange{
+			{Path: "data.csv", Content: []byte("g\n"), WriteMode: WriteModeAppend},
+			{Path: "same.txt", Content: []byte("same\n")},
+			{Path: "new.txt", Content: []byte("x")},
+		})
+		if err != nil {
+			t.Fatalf("PreviewCommit failed: %s", err)
+		}
+		if fake.refs["main"] != before || len(fake.blobs) != blobs {
+			t.Fatalf("expected a preview not to write to the repository")
+		}
+		if preview.Base != before {
+			t.Fatalf("unexpected base: %s", preview.Base)
+		}
+		if reads != 1 {
+			t.Fatalf("expected data.csv to be read once, got %d blob reads", reads)
+		}
+
+		for i, want := range []FilePreview{
+			{
+				FileResult: FileResult{Path: "data.csv", Bytes: 14, WriteMode: "append", Status: "changed"},
+				Content:    "a\nb\nc\nd\ne\nf\ng\n",
+				Diff:       "--- a/data.csv\n+++ b/data.csv\n@@ -4,3 +4,4 @@\n d\n e\n f\n+g\n",
+			},
+			{
+				FileResult: FileResult{Path: "same.txt", Bytes: 5, WriteMode: "overwrite", Status: "unchanged"},
+				Content:    "same\n",
+			},
+			{
+				FileResult: FileResult{Path: "new.txt", Bytes: 1, WriteMode: "overwrite", Status: "changed"},
+				Content:    "x",
+				Diff:       "--- /dev/null\n+++ b/new.txt\n@@ -0,0 +1 @@\n+x\n\\ No newline at end of file\n",
+			},
+		} {
+			if preview.Files[i] != want {
+				t.Fatalf("unexpected preview:\nwant %+v\ngot  %+v", want, preview.Files[i])
+			}
+		}
+	})
+}
+
+func TestCommitToGitFailsWhenExistingFileIsUnreadable(t *testing.T) {
+	wadge.RunTest(t, func() {
+		fake := newFakeGitHub(map[string]string{"data.csv": "a\n"})
+		server := httptest.NewServer(fake)
+		defer server.Close()
+
+		delete(fake.blobs, gitBlobSHA([]byte("a\n")))
+		before := fake.refs["main"]
+
+		_, err := CommitToGit(newGitHubCommitter(newTestDestination(server.URL)), &SettingsConfig{}, []FileChange{
+			{Path: "data.csv", Content: []byte("b\n"), WriteMode: WriteModeAppend},
+		})
+		if err == nil || !strings.Contains(err.Error(), "failed to read existing content") {
+			t.Fatalf("expected a read error, got %v", err)
+		}
+		if fake.refs["main"] != before {
+			t.Fatalf("expected the branch to stay at %s", before)
+		}
+	})
+}
+
+func TestCommitToGitFailsWhenTreeListingIsTruncated(t *testing.T) {
+	wadge.RunTest(t, func() {
+		fake := newFakeGitHub(map[string]string{"data.csv": "a\n"})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/repos/owner/repo/git/trees/") {
+				writeFakeJSON(w, map[string]interface{}{"tree": []map[string]string{}, "truncated": true})
+				return
+			}
+			fake.ServeHTTP(w, r)
+		}))
+		defer server.Close()
+
+		before := fake.refs["main"]
+		_, err := CommitToGit(newGitHubCommitter(newTestDestination(server.URL)), &SettingsConfig{}, []FileChange{
+			{Path: "data.csv", Content: []byte("b\n"), WriteMode: WriteModeAppend},
+		})
+		if err == nil || !strings.Contains(err.Error(), "too large") {
+			t.Fatalf("expected a truncated tree error, got %v", err)
+		}
+		if fake.refs["main"] != before {
+			t.Fatalf("expected the branch to stay at %s", before)
+		}
+	})
+}
+
+func TestCommitToGitCreatesMissingBranch(t *testing.T) {
+	wadge.RunTest(t, func() {
+		fake := newFakeGitHub(map[string]string{"README.md": "data\n"})
+		server := httptest.NewServer(fake)
+		defer server.Close()
+
+		cfg := newTestDestination(server.URL)
+		cfg.Branch = "data"
+		changes := []FileChange{{Path: "out.json", Content: []byte("{}")}}
+
+		_, err := CommitToGit(newGitHubCommitter(cfg), &SettingsConfig{}, changes)
+		if err == nil || !strings.Contains(err.Error(), "create_branch_from") {
+			t.Fatalf("expected a missing branch error, got %v", err)
+		}
+
+		cfg.CreateBranchFrom = "main"
+		result, err := CommitToGit(newGitHubCommitter(cfg), &SettingsConfig{}, changes)
+		if err != nil {
+			t.Fatalf("CommitToGit failed: %s", err)
+		}
+		if result.ParentSHA != fake.refs["main"] || fake.refs["data"] != result.SHA {
+			t.Fatalf("expected data to be created on top of main, got parent %s", result.ParentSHA)
+		}
+		if got, _ := fake.file("data", "README.md"); got != "data\n" {
+			t.Fatalf("expected the new branch to keep main's files, got %q", got)
+		}
+	})
+}
+
+func TestCommitToGitMakesInitialCommitInEmptyRepository(t *testing.T) {
+	wadge.RunTest(t, func() {
+		fake := newFakeGitHub(nil)
+		server := httptest.NewServer(fake)
+		defer server.Close()
+
+		cfg := newTestDestination(server.URL)
+		cfg.Author = IdentityConfig{Name: "Bot", Email: "bot@example.com"}
+		cfg.Committer = IdentityConfig{Name: "CI", Email: "ci@example.com"}
+		result, err := CommitToGit(newGitHubCommitter(cfg), &SettingsConfig{}, []FileChange{
+			{Path: "data.csv", Content: []byte("a\n"), WriteMode: WriteModeAppend},
+			{Path: "run.sh", Content: []byte("#!/bin/sh\n"), Mode: FileModeExecutable},
+		})
+		if err != nil {
+			t.Fatalf("CommitToGit failed: %s", err)
+		}
+		bootstrap := fake.commits[result.SHA].Parents
+		if result.ParentSHA != "" || len(bootstrap) != 1 || len(fake.commits[bootstrap[0]].Parents) != 0 {
+			t.Fatalf("expected a commit on top of the root bootstrap commit, got parents %v", bootstrap)
+		}
+		root := fake.commits[bootstrap[0]]
+		if root.Author["name"] != "Bot" || root.Committer["email"] != "ci@example.com" {
+			t.Fatalf("expected the bootstrap commit to carry the configured identities, got %v and %v", root.Author, root.Committer)
+		}
+		if fake.refs["main"] != result.SHA {
+			t.Fatalf("expected main to point at the initial commit")
+		}
+		if got, _ := fake.file("main", "data.csv"); got != "a\n" {
+			t.Fatalf("unexpected data.csv content: %q", got)
+		}
+		if got := fake.trees[fake.commits[result.SHA].Tree]["run.sh"].Mode; got != FileModeExecutable {
+			t.Fatalf("unexpected run.sh mode: %s", got)
+		}
+	})
+}
+
+func TestCommitToGitRefusesSignedInitialCommit(t *testing.T) {
+	wadge.RunTest(t, func() {
+		fake := newFakeGitHub(nil)
+		server := httptest.NewServer(fake)
+		defer server.Close()
+
+		cfg := newTestDestination(server.URL)
+		cfg.Author = IdentityConfig{Name: "Bot", Email: "bot@example.com"}
+		cfg.Signing = SigningSSH
+		cfg.SigningKey = testSSHSigningKey
+		_, err := CommitToGit(newGitHubCommitter(cfg), &SettingsConfig{}, []FileChange{{Path: "data.csv", Content: []byte("a\n")}})
+		if err == nil || !strings.Contains(err.Error(), "signed first commit") {
+			t.Fatalf("expected a signed commit in an empty repository to be refused, got %v", err)
+		}
+		if len(fake.commits) != 0 {
+			t.Fatalf("expected no unsigned commit to be pushed, got %d commits", len(fake.commits))
+		}
+	})
+}
+
+func TestCommitToGitRetriesWhenBranchMovedAfterBootstrap(t *testing.T) {
+	wadge.RunTest(t, func() {
+		fake := newFakeGitHub(nil)
+		var concurrent string
+		fake.beforeUpdate = func(f *fakeGitHub) {
+			concurrent = f.commitFiles(f.refs["main"], map[string]string{"other.txt": "x"})
+			f.refs["main"] = concurrent
+			f.beforeUpdate = nil
+		}
+		server := httptest.NewServer(fake)
+		defer server.Close()
+
+		result, err := CommitToGit(newGitHubCommitter(newTestDestination(server.URL)), &SettingsConfig{}, []FileChange{
+			{Path: "data.csv", Content: []byte("a\n")},
+			{Path: "run.sh", Content: []byte("#!/bin/sh\n"), Mode: FileModeExecutable},
+		})
+		if err != nil {
+			t.Fatalf("CommitToGit failed: %s", err)
+		}
+		if result.Retries != 1 || result.ParentSHA != concurrent {
+			t.Fatalf("expected one retry on top of the concurrent commit, got %+v", result)
+		}
+		if got, ok := fake.file("main", "other.txt"); !ok || got != "x" {
+			t.Fatalf("the concurrent commit was lost")
+		}
+		if got, _ := fake.file("main", "run.sh"); got != "#!/bin/sh\n" {
+			t.Fatalf("unexpected run.sh content: %q", got)
+		}
+	})
+}
+
+func TestCommitToGitSignsCommitWithSSHKey(t *testing.T) {
+	wadge.RunTest(t, func() {
+		fake := newFakeGitHub(map[string]string{})
+		server := httptest.NewServer(fake)
+		defer server.Close()
+
+		cfg := newTestDestination(server.URL)
+		cfg.Author = IdentityConfig{Name: "Bot", Email: "bot@example.com", Date: "2026-10-16T12:00:00+02:00"}
+		cfg.Signing = SigningSSH
+		cfg.SigningKey = testSSHSigningKey
+		c, err := newCommitter(cfg)
+		if err != nil {
+			t.Fatalf("newCommitter failed: %s", err)
+		}
+
+		result, err := CommitToGit(c, &SettingsConfig{}, []FileChange{{Path: "out.json", Content: []byte("{}")}})
+		if err != nil {
+			t.Fatalf("CommitToGit failed: %s", err)
+		}
+		commit := fake.commits[result.SHA]
+		for _, id := range []map[string]interface{}{commit.Author, commit.Committer} {
+			if id["name"] != "Bot" || id["email"] != "bot@example.com" || id["date"] != "2026-10-16T12:00:00+02:00" {
+				t.Fatalf("unexpected identity: %v", id)
+			}
+		}
+
+		// Verify the SSHSIG over the commit object as git would rebuild it.
+		object := fmt.Sprintf("tree %s\nparent %s\nauthor Bot <bot@example.com> 1792144800 +0200\n"+
+			"committer Bot <bot@example.com> 1792144800 +0200\n\nupdate", commit.Tree, commit.Parents[0])
+		block, _ := pem.Decode([]byte(commit.Signature))
+		if block == nil || block.Type != "SSH SIGNATURE" {
+			t.Fatalf("expected an armored SSH signature, got %q", commit.Signature)
+		}
+		var blob struct {
+			Version   uint32
+			PublicKey []byte
+			Namespace string
+			Reserved  string
+			HashAlg   string
+			Signature []byte
+		}
+		if err := ssh.Unmarshal(block.Bytes[len("SSHSIG"):], &blob); err != nil {
+			t.Fatalf("failed to parse the signature: %s", err)
+		}
+		public, err := ssh.ParsePublicKey(blob.PublicKey)
+		if err != nil {
+			t.Fatalf("failed to parse the public key: %s", err)
+		}
+		sig := new(ssh.Signature)
+		if err := ssh.Unmarshal(blob.Signature, sig); err != nil {
+			t.Fatalf("failed to parse the signature: %s", err)
+		}
+
+		digest := sha512.Sum512([]byte(object))
+		signed := append([]byte("SSHSIG"), ssh.Marshal(struct {
+			Namespace, Reserved, HashAlg string
+			Hash                         []byte
+		}{"git", "", "sha512", digest[:]})...)
+		if err := public.Verify(signed, sig); err != nil {
+			t.Fatalf("signature does not verify")
+		}
+	})
+}
+
+func TestNewCommitterRejectsUnsupportedSigning(t *testing.T) {
+	cfg := newTestDestination("https://gitlab.example.com/api/v4")
+	cfg.Type = DestinationGitLab
+	cfg.Author = IdentityConfig{Name: "Bot", Email: "bot@example.com"}
+	cfg.Signing = SigningSSH
+	cfg.SigningKey = testSSHSigningKey
+	if _, err := newCommitter(cfg); err == nil || !strings.Contains(err.Error(), "only supported for github") {
+		t.Fatalf("expected signing to be rejected for gitlab, got %v", err)
+	}
+
+	cfg.Type = DestinationGitHub
+	cfg.Author = IdentityConfig{}
+	if _, err := newCommitter(cfg); err == nil || !strings.Contains(err.Error(), "needs destination.author") {
+		t.Fatalf("expected signing without an author to be rejected, got %v", err)
+	}
+
+	cfg.Signing = ""
+	cfg.Committer = IdentityConfig{Name: "Bot", Email: "bot@example.com"}
+	if _, err := newCommitter(cfg); err == nil || !strings.Contains(err.Error(), "committer needs destination.author") {
+		t.Fatalf("expected a committer without an author to be rejected, got %v", err)
+	}
+
+	if _, err := commitObject("tree", nil, nil, nil, "message"); err == nil {
+		t.Fatalf("expected a commit object without an author to be rejected")
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// giteaCommitter writes to a Gitea or Forgejo repository through the
+// multi-file contents API, which applies a list of file operations as a
+// single commit.
+type giteaCommitter struct {
+	cfg *DestinationConfig
+	// files caches getFile by "<commit>:<path>"; nil marks a missing file.
+	files map[string]*giteaFile
+}
+
+type giteaFile struct {
+	Type     string `json:"type"`
+	SHA      string `json:"sha"`
+	Content  string `json:"content"`
+	Encoding string `json:"encoding"`
+}
+
+func newGiteaCommitter(cfg *DestinationConfig) *giteaCommitter {
+	return &giteaCommitter{cfg: cfg, files: map[string]*giteaFile{}}
+}
+
+func (g *giteaCommitter) repoURL() string {
+	return fmt.Sprintf("%s/repos/%s/%s", g.cfg.APIURL, url.PathEscape(g.cfg.Owner), url.PathEscape(g.cfg.Repo))
+}
+
+func (g *giteaCommitter) Head() (string, error) {
+	url := fmt.Sprintf("%s/branches/%s", g.repoURL(), url.PathEscape(g.cfg.Branch))
+
+	var branchData struct {
+		Commit struct {
+			ID string `json:"id"`
+		} `json:"commit"`
+	}
+
+	if err := giteaAPIRequest("GET", url, g.cfg.Token, nil, &branchData); err != nil {
+		return "", fmt.Errorf("failed to get branch: %w", err)
+	}
+
+	return branchData.Commit.ID, nil
+}
+
+// Lookup reports symlinks as such; the contents API does not expose the
+// executable bit, so every other file is reported as a regular file.
+func (g *giteaCommitter) Lookup(ref, path string) (*fileState, error) {
+	file, err := g.getFile(ref, path)
+	if err != nil || file == nil {
+		return nil, err
+	}
+
+	switch file.Type {
+	case "file":
+		return &fileState{Mode: FileModeRegular, SHA: file.SHA}, nil
+	case "symlink":
+		return &fileState{Mode: FileModeSymlink, SHA: file.SHA}, nil
+	default:
+		return nil, fmt.Errorf("path '%s' exists as a %s", path, file.Type)
+	}
+}
+
+func (g *giteaCommitter) ReadFile(ref, path string) ([]byte, error) {
+	file, err := g.getFile(ref, path)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, fmt.Errorf("file '%s' not found at %s", path, ref)
+	}
+	// Files over the server's API blob size limit come without content.
+	if file.Encoding != "base64" {
+		return g.getBlob(file.SHA)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(file.Content, "\n", ""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode file content: %w", err)
+	}
+	return decoded, nil
+}
+
+func (g *giteaCommitter) getBlob(sha string) ([]byte, error) {
+	url := fmt.Sprintf("%s/git/blobs/%s", g.repoURL(), sha)
+
+	var blobData struct {
+		Content  string `json:"content"`
+		Encoding string `json:"encoding"`
+		Size     int    `json:"size"`
+	}
+
+	if err := giteaAPIRequest("GET", url, g.cfg.Token, nil, &blobData); err != nil {
+		return nil, fmt.Errorf("failed to get blob: %w", err)
+	}
+	if blobData.Encoding != "base64" {
+		return nil, fmt.Errorf("unexpected encoding: %s", blobData.Encoding)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(blobData.Content, "\n", ""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode blob content: %w", err)
+	}
+	if len(decoded) != blobData.Size {
+		return nil, fmt.Errorf("blob %s is %d bytes but only %d were returned", sha, blobData.Size, len(decoded))
+	}
+	return decoded, nil
+}
+
+// Commit sends every change as a file operation. Updates and deletes carry the
+// blob SHA seen at base, so the server rejects the commit if another writer
+// changed the same files in the meantime.
+func (g *giteaCommitter) Commit(base string, changes []stagedChange) (string, error) {
+	if len(changes) == 0 {
+		return "", fmt.Errorf("%s does not support empty commits", g.cfg.Type)
+	}
+
+	files := make([]map[string]interface{}, 0, len(changes))
+	for _, change := range changes {
+		path := strings.Trim(change.Path, "/")
+
+		if change.Delete {
+			files = append(files, map[string]interface{}{
+				"operation": "delete",
+				"path":      path,
+				"sha":       change.Current.SHA,
+			})
+			continue
+		}
+		if fileMode(change.FileChange) != FileModeRegular {
+			return "", fmt.Errorf("path '%s': %s only supports regular files", path, g.cfg.Type)
+		}
+
+		file := map[string]interface{}{
+			"operation": "create",
+			"path":      path,
+			"content":   base64.StdEncoding.EncodeToString(change.Content),
+		}
+		if change.Current != nil {
+			file["operation"] = "update"
+			file["sha"] = change.Current.SHA
+		}
+		files = append(files, file)
+	}
+
+	payload := map[string]interface{}{
+		"branch":  g.cfg.Branch,
+		"message": commitMessage(g.cfg),
+		"files":   files,
+	}
+	author, committer, err := commitIdentities(g.cfg, time.Now())
+	if err != nil {
+		return "", err
+	}
+	if author != nil {
+		payload["author"] = map[string]string{"name": author.Name, "email": author.Email}
+		payload["committer"] = map[string]string{"name": committer.Name, "email": committer.Email}
+		payload["dates"] = map[string]string{
+			"author":    author.When.Format(time.RFC3339),
+			"committer": committer.When.Format(time.RFC3339),
+		}
+	}
+
+	var commitData struct {
+		Commit struct {
+			SHA string `json:"sha"`
+		} `json:"commit"`
+	}
+
+	url := fmt.Sprintf("%s/contents", g.repoURL())
+	if err := giteaAPIRequest("POST", url, g.cfg.Token, payload, &commitData); err != nil {
+		var apiErr *apiError
+		if errors.As(err, &apiErr) && isGiteaConflict(apiErr) {
+			return "", fmt.Errorf("%w: %s", errBranchMoved, apiErr.Body)
+		}
+		return "", fmt.Errorf("failed to create commit: %w", err)
+	}
+
+	return commitData.Commit.SHA, nil
+}
+
+func (g *giteaCommitter) CommitURL(sha string) string {
+	return fmt.Sprintf("%s/%s/%s/commit/%s", webURL(g.cfg.APIURL, "/api/v1"), g.cfg.Owner, g.cfg.Repo, sha)
+}
+
+// isGiteaConflict recognises the errors returned when a file was created,
+// changed or removed since it was read. Depending on the version these come
+// back as 409 or 422.
+func isGiteaConflict(err *apiError) bool {
+	if err.StatusCode != http.StatusConflict && err.StatusCode != http.StatusUnprocessableEntity {
+		return false
+	}
+	body := strings.ToLower(err.Body)
+	for _, msg := range []string{"sha does not match", "already exists", "does not exist"} {
+		if strings.Contains(body, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// getFile fetches path at ref, returning nil if it does not exist.
+func (g *giteaCommitter) getFile(ref, path string) (*giteaFile, error) {
+	key := ref + ":" + path
+	if file, ok := g.files[key]; ok {
+		return file, nil
+	}
+
+	url := fmt.Sprintf("%s/contents/%s?ref=%s", g.repoURL(), escapePathSegments(path), url.QueryEscape(ref))
+
+	var raw json.RawMessage
+	if err := giteaAPIRequest("GET", url, g.cfg.Token, nil, &raw); err != nil {
+		var apiErr *apiError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			g.files[key] = nil
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get file: %w", err)
+	}
+	// The contents API lists a directory as an array of its entries.
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '[' {
+		return nil, fmt.Errorf("path '%s' is a directory", path)
+	}
+	var file giteaFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("failed to decode file '%s': %w", path, err)
+	}
+	if file.Type == "dir" {
+		return nil, fmt.Errorf("path '%s' is a directory", path)
+	}
+
+	g.files[key] = &file
+	return &file, nil
+}
+
+// escapePathSegments escapes each segment of a repository path for use in a
+// URL, keeping the separating slashes.
+func escapePathSegments(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func giteaAPIRequest(method, url, token string, payload, response interface{}) error {
+	return jsonAPIRequest(method, url, map[string]string{
+		"Authorization": "token " + token,
+		"Accept":        "application/json",
+	}, payload, response)
+}
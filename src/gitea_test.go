@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"go.wasmcloud.dev/wadge"
+)
+
+// fakeGitea is an in-memory stand-in for the Gitea branches and contents
+// APIs of repository "owner/repo". It only tracks the branch head.
+type fakeGitea struct {
+	fakeHost
+	// operations holds the file operations of the last commit request.
+	operations []map[string]interface{}
+}
+
+func newFakeGitea(files map[string]string) *fakeGitea {
+	f := &fakeGitea{}
+	f.init(files)
+	return f
+}
+
+func (f *fakeGitea) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "token token" {
+		http.Error(w, `{"message":"token is required"}`, http.StatusUnauthorized)
+		return
+	}
+
+	const prefix = "/api/v1/repos/owner/repo/"
+	if !strings.HasPrefix(r.URL.EscapedPath(), prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.EscapedPath(), prefix)
+
+	switch {
+	case r.Method == http.MethodGet && path == "branches/main":
+		writeFakeJSON(w, map[string]interface{}{"commit": map[string]string{"id": f.head}})
+
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "contents/"):
+		name, _ := url.PathUnescape(strings.TrimPrefix(path, "contents/"))
+		content, ok := f.files[name]
+		if !ok || r.URL.Query().Get("ref") != f.head {
+			http.Error(w, `{"message":"GetContentsOrList"}`, http.StatusNotFound)
+			return
+		}
+		writeFakeJSON(w, giteaFile{
+			Type:     "file",
+			SHA:      gitBlobSHA([]byte(content)),
+			Content:  base64.StdEncoding.EncodeToString([]byte(content)),
+			Encoding: "base64",
+		})
+
+	case r.Method == http.MethodPost && path == "contents":
+		f.runBeforeCommit()
+		var body struct {
+			Branch string                   `json:"branch"`
+			Files  []map[string]interface{} `json:"files"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		f.operations = body.Files
+
+		files := map[string]string{}
+		for name, content := range f.files {
+			files[name] = content
+		}
+		for _, op := range body.Files {
+			name := op["path"].(string)
+			current, exists := files[name]
+			if op["operation"] == "create" {
+				if exists {
+					http.Error(w, `{"message":"repository file already exists [path: `+name+`]"}`, http.StatusUnprocessableEntity)
+					return
+				}
+			} else if !exists || op["sha"] != gitBlobSHA([]byte(current)) {
+				http.Error(w, `{"message":"sha does not match [given: x, expected: y]"}`, http.StatusConflict)
+				return
+			}
+			if op["operation"] == "delete" {
+				delete(files, name)
+				continue
+			}
+			content, _ := base64.StdEncoding.DecodeString(op["content"].(string))
+			files[name] = string(content)
+		}
+		f.files = files
+		f.head = f.nextCommit()
+		w.WriteHeader(http.StatusCreated)
+		writeFakeJSON(w, map[string]interface{}{"commit": map[string]string{"sha": f.head}})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestGiteaDestination(url string) *DestinationConfig {
+	return &DestinationConfig{
+		Type:          DestinationGitea,
+		APIURL:        url + "/api/v1",
+		Owner:         "owner",
+		Repo:          "repo",
+		Branch:        "main",
+		CommitMessage: "update",
+		Token:         "token",
+	}
+}
+
+func TestGiteaCommitterSendsFileOperations(t *testing.T) {
+	wadge.RunTest(t, func() {
+		fake := newFakeGitea(map[string]string{"data.csv": "a\n", "old.txt": "x", "same.json": "{}"})
+		server := httptest.NewServer(fake)
+		defer server.Close()
+
+		c, err := newCommitter(newTestGiteaDestination(server.URL))
+		if err != nil {
+			t.Fatalf("newCommitter failed: %s", err)
+		}
+		_, err = CommitToGit(c, &SettingsConfig{}, []FileChange{
+			{Path: "data.csv", Content: []byte("b\n"), WriteMode: WriteModeAppend},
+			{Path: "reports/new.json", Content: []byte("[]")},
+			{Path: "old.txt", Delete: true},
+			{Path: "same.json", Content: []byte("{}")},
+		})
+		if err != nil {
+			t.Fatalf("CommitToGit failed: %s", err)
+		}
+
+		want := []map[string]interface{}{
+			{"operation": "update", "path": "data.csv", "sha": gitBlobSHA([]byte("a\n"))},
+			{"operation": "create", "path": "reports/new.json"},
+			{"operation": "delete", "path": "old.txt", "sha": gitBlobSHA([]byte("x"))},
+		}
+		if len(fake.operations) != len(want) {
+			t.Fatalf("expected %d file operations, got %v", len(want), fake.operations)
+		}
+		for i, op := range fake.operations {
+			for key, value := range want[i] {
+				if op[key] != value {
+					t.Fatalf("operation %d: want %s %v, got %v", i, key, value, op)
+				}
+			}
+			if _, ok := op["sha"]; ok != (op["operation"] != "create") {
+				t.Fatalf("operation %d: expected sha only on updates and deletes, got %v", i, op)
+			}
+		}
+	})
+}
+
+func TestIsGiteaConflict(t *testing.T) {
+	for _, tt := range []struct {
+		err  apiError
+		want bool
+	}{
+		{apiError{StatusCode: http.StatusConflict, Body: `{"message":"sha does not match [given: a, expected: b]"}`}, true},
+		{apiError{StatusCode: http.StatusUnprocessableEntity, Body: `{"message":"repository file already exists [path: data.csv]"}`}, true},
+		{apiError{StatusCode: http.StatusUnprocessableEntity, Body: `{"message":"repository file does not exist [path: data.csv]"}`}, true},
+		{apiError{StatusCode: http.StatusUnprocessableEntity, Body: `{"message":"branch name is invalid"}`}, false},
+		{apiError{StatusCode: http.StatusInternalServerError, Body: `{"message":"sha does not match"}`}, false},
+	} {
+		if got := isGiteaConflict(&tt.err); got != tt.want {
+			t.Errorf("isGiteaConflict(%d %s) = %v, want %v", tt.err.StatusCode, tt.err.Body, got, tt.want)
+		}
+	}
+}
+
+func TestGiteaGetFileEscapesPathAndRejectsDirectories(t *testing.T) {
+	wadge.RunTest(t, func() {
+		var requested []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested = append(requested, r.URL.EscapedPath())
+			switch r.URL.EscapedPath() {
+			case "/repos/owner/repo/contents/reports/power%20usage%231.json":
+				writeFakeJSON(w, giteaFile{Type: "file", SHA: "abc", Content: base64.StdEncoding.EncodeToString([]byte("1\n")), Encoding: "base64"})
+			case "/repos/owner/repo/contents/reports":
+				writeFakeJSON(w, []giteaFile{{Type: "file", SHA: "abc"}})
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer server.Close()
+
+		g := newGiteaCommitter(newTestDestination(server.URL))
+		file, err := g.getFile("main", "/reports/power usage#1.json")
+		if err != nil {
+			t.Fatalf("getFile failed: %s (requested %v)", err, requested)
+		}
+		if file == nil || file.SHA != "abc" {
+			t.Fatalf("unexpected file %+v", file)
+		}
+
+		if _, err := g.getFile("main", "reports"); err == nil || !strings.Contains(err.Error(), "path 'reports' is a directory") {
+			t.Fatalf("expected a directory error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.wasmcloud.dev/wadge"
+)
+
+func TestGitHubAppAuthenticatesWithCachedInstallationToken(t *testing.T) {
+	wadge.RunTest(t, func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		privatePEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+		fake := newFakeGitHub(map[string]string{"data.csv": "a\n"})
+		tokensIssued := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if r.URL.Path == "/app/installations/42/access_tokens" {
+				if err := verifyTestJWT(auth, &key.PublicKey, "1234"); err != nil {
+					http.Error(w, `{"message":"`+err.Error()+`"}`, http.StatusUnauthorized)
+					return
+				}
+				tokensIssued++
+				writeFakeJSON(w, map[string]string{
+					"token":      "ghs_installation",
+					"expires_at": time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+				})
+				return
+			}
+			if auth != "ghs_installation" {
+				http.Error(w, `{"message":"Bad credentials"}`, http.StatusUnauthorized)
+				return
+			}
+			fake.ServeHTTP(w, r)
+		}))
+		defer server.Close()
+
+		cfg := newTestDestination(server.URL)
+		cfg.Token = ""
+		cfg.GitHubApp = GitHubAppConfig{AppID: "1234", InstallationID: "42", PrivateKey: string(privatePEM)}
+		c, err := newCommitter(cfg)
+		if err != nil {
+			t.Fatalf("newCommitter failed: %s", err)
+		}
+
+		for i := 0; i < 2; i++ {
+			if _, err := CommitToGit(c, &SettingsConfig{}, []FileChange{
+				{Path: "data.csv", Content: []byte("b\n"), WriteMode: WriteModeAppend},
+			}); err != nil {
+				t.Fatalf("CommitToGit failed: %s", err)
+			}
+		}
+		if tokensIssued != 1 {
+			t.Fatalf("expected the installation token to be cached, got %d token requests", tokensIssued)
+		}
+
+		// A token about to expire is replaced before use.
+		cacheKey := server.URL + "|1234|42"
+		installationTokens.byKey[cacheKey] = installationToken{token: "ghs_stale", expiresAt: time.Now().Add(time.Minute)}
+		if _, err := c.Head(); err != nil {
+			t.Fatalf("Head failed: %s", err)
+		}
+		if tokensIssued != 2 {
+			t.Fatalf("expected an expiring token to be refreshed, got %d token requests", tokensIssued)
+		}
+	})
+}
+
+func verifyTestJWT(jwt string, key *rsa.PublicKey, issuer string) error {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed JWT")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+	var claims struct {
+		Iss string `json:"iss"`
+		Iat int64  `json:"iat"`
+		Exp int64  `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return err
+	}
+	if claims.Iss != issuer || claims.Exp-claims.Iat > 600 || claims.Exp < time.Now().Unix() {
+		return errors.New("unexpected JWT claims")
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// gitlabCommitter writes to a GitLab project through the Commits API, which
+// applies a list of file actions as a single commit.
+type gitlabCommitter struct {
+	cfg *DestinationConfig
+	// files caches getFile by "<commit>:<path>"; nil marks a missing file.
+	files map[string]*gitlabFile
+}
+
+type gitlabFile struct {
+	BlobID          string `json:"blob_id"`
+	LastCommitID    string `json:"last_commit_id"`
+	Content         string `json:"content"`
+	Encoding        string `json:"encoding"`
+	ExecuteFilemode bool   `json:"execute_filemode"`
+}
+
+func newGitLabCommitter(cfg *DestinationConfig) *gitlabCommitter {
+	return &gitlabCommitter{cfg: cfg, files: map[string]*gitlabFile{}}
+}
+
+// projectURL addresses the project by its URL-encoded "namespace/project" path.
+func (g *gitlabCommitter) projectURL() string {
+	return fmt.Sprintf("%s/projects/%s", g.cfg.APIURL, url.PathEscape(g.cfg.Owner+"/"+g.cfg.Repo))
+}
+
+func (g *gitlabCommitter) Head() (string, error) {
+	url := fmt.Sprintf("%s/repository/branches/%s", g.projectURL(), url.PathEscape(g.cfg.Branch))
+
+	var branchData struct {
+		Commit struct {
+			ID string `json:"id"`
+		} `json:"commit"`
+	}
+
+	if err := gitlabAPIRequest("GET", url, g.cfg.Token, nil, &branchData); err != nil {
+		return "", fmt.Errorf("failed to get branch: %w", err)
+	}
+
+	return branchData.Commit.ID, nil
+}
+
+func (g *gitlabCommitter) Lookup(ref, path string) (*fileState, error) {
+	file, err := g.getFile(ref, path)
+	if err != nil || file == nil {
+		return nil, err
+	}
+
+	mode := FileModeRegular
+	if file.ExecuteFilemode {
+		mode = FileModeExecutable
+	}
+	return &fileState{Mode: mode, SHA: file.BlobID, Revision: file.LastCommitID}, nil
+}
+
+func (g *gitlabCommitter) ReadFile(ref, path string) ([]byte, error) {
+	file, err := g.getFile(ref, path)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, fmt.Errorf("file '%s' not found at %s", path, ref)
+	}
+	if file.Encoding != "base64" {
+		return nil, fmt.Errorf("unexpected encoding: %s", file.Encoding)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(file.Content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode file content: %w", err)
+	}
+	return decoded, nil
+}
+
+// Commit sends every change as a commit action. Updates and deletes carry the
+// last commit ID of the file seen at base, so GitLab rejects the commit if
+// another writer touched the same files in the meantime.
+func (g *gitlabCommitter) Commit(base string, changes []stagedChange) (string, error) {
+	if len(changes) == 0 {
+		return "", fmt.Errorf("gitlab does not support empty commits")
+	}
+
+	var actions []map[string]interface{}
+	for _, change := range changes {
+		path := strings.Trim(change.Path, "/")
+		mode := fileMode(change.FileChange)
+
+		if change.Delete {
+			actions = append(actions, map[string]interface{}{
+				"action":         "delete",
+				"file_path":      path,
+				"last_commit_id": change.Current.Revision,
+			})
+			continue
+		}
+		if mode == FileModeSymlink {
+			return "", fmt.Errorf("path '%s': gitlab does not support symlinks", path)
+		}
+
+		action := map[string]interface{}{
+			"action":    "create",
+			"file_path": path,
+			"content":   base64.StdEncoding.EncodeToString(change.Content),
+			"encoding":  "base64",
+		}
+		currentMode := FileModeRegular
+		if change.Current != nil {
+			action["action"] = "update"
+			action["last_commit_id"] = change.Current.Revision
+			currentMode = change.Current.Mode
+		}
+		actions = append(actions, action)
+
+		if mode != currentMode {
+			actions = append(actions, map[string]interface{}{
+				"action":           "chmod",
+				"file_path":        path,
+				"execute_filemode": mode == FileModeExecutable,
+			})
+		}
+	}
+
+	payload := map[string]interface{}{
+		"branch":         g.cfg.Branch,
+		"commit_message": commitMessage(g.cfg),
+		"actions":        actions,
+	}
+	if g.cfg.Author.Name != "" {
+		payload["author_name"] = g.cfg.Author.Name
+		payload["author_email"] = g.cfg.Author.Email
+	}
+
+	var commitData struct {
+		ID string `json:"id"`
+	}
+
+	url := fmt.Sprintf("%s/repository/commits", g.projectURL())
+	if err := gitlabAPIRequest("POST", url, g.cfg.Token, payload, &commitData); err != nil {
+		var apiErr *apiError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest && isGitLabConflict(apiErr.Body) {
+			return "", fmt.Errorf("%w: %s", errBranchMoved, apiErr.Body)
+		}
+		return "", fmt.Errorf("failed to create commit: %w", err)
+	}
+
+	return commitData.ID, nil
+}
+
+func (g *gitlabCommitter) CommitURL(sha string) string {
+	return fmt.Sprintf("%s/%s/%s/-/commit/%s", webURL(g.cfg.APIURL, "/api/v4"), g.cfg.Owner, g.cfg.Repo, sha)
+}
+
+// isGitLabConflict recognises the commit action errors GitLab returns when a
+// file was created, changed or removed since it was read.
+func isGitLabConflict(body string) bool {
+	for _, msg := range []string{
+		"has changed since you started editing it",
+		"A file with this name already exists",
+		"A file with this name doesn't exist",
+	} {
+		if strings.Contains(body, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// getFile fetches path at ref, returning nil if it does not exist.
+func (g *gitlabCommitter) getFile(ref, path string) (*gitlabFile, error) {
+	key := ref + ":" + path
+	if file, ok := g.files[key]; ok {
+		return file, nil
+	}
+
+	url := fmt.Sprintf("%s/repository/files/%s?ref=%s",
+		g.projectURL(), url.PathEscape(strings.Trim(path, "/")), url.QueryEscape(ref))
+
+	var file gitlabFile
+	if err := gitlabAPIRequest("GET", url, g.cfg.Token, nil, &file); err != nil {
+		var apiErr *apiError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			g.files[key] = nil
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get file: %w", err)
+	}
+
+	g.files[key] = &file
+	return &file, nil
+}
+
+func gitlabAPIRequest(method, url, token string, payload, response interface{}) error {
+	return jsonAPIRequest(method, url, map[string]string{
+		"PRIVATE-TOKEN": token,
+		"Accept":        "application/json",
+	}, payload, response)
+}
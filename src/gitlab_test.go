@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"go.wasmcloud.dev/wadge"
+)
+
+// fakeGitLab is an in-memory stand-in for the GitLab branches, files and
+// commits APIs of project "group/project". It only tracks the branch head.
+type fakeGitLab struct {
+	fakeHost
+	executable map[string]bool
+}
+
+func newFakeGitLab(files map[string]string) *fakeGitLab {
+	f := &fakeGitLab{executable: map[string]bool{}}
+	f.init(files)
+	return f
+}
+
+func (f *fakeGitLab) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("PRIVATE-TOKEN") != "token" {
+		http.Error(w, `{"message":"401 Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	const prefix = "/api/v4/projects/group%2Fproject/repository/"
+	if !strings.HasPrefix(r.URL.EscapedPath(), prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.EscapedPath(), prefix)
+
+	switch {
+	case r.Method == http.MethodGet && path == "branches/main":
+		writeFakeJSON(w, map[string]interface{}{"commit": map[string]string{"id": f.head}})
+
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "files/"):
+		name, _ := url.PathUnescape(strings.TrimPrefix(path, "files/"))
+		content, ok := f.files[name]
+		if !ok || r.URL.Query().Get("ref") != f.head {
+			http.Error(w, `{"message":"404 File Not Found"}`, http.StatusNotFound)
+			return
+		}
+		writeFakeJSON(w, map[string]interface{}{
+			"blob_id":          gitBlobSHA([]byte(content)),
+			"last_commit_id":   f.changed[name],
+			"content":          base64.StdEncoding.EncodeToString([]byte(content)),
+			"encoding":         "base64",
+			"execute_filemode": f.executable[name],
+		})
+
+	case r.Method == http.MethodPost && path == "commits":
+		f.runBeforeCommit()
+		var body struct {
+			Branch  string                   `json:"branch"`
+			Actions []map[string]interface{} `json:"actions"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+
+		sha := f.nextCommit()
+		for _, action := range body.Actions {
+			name := action["file_path"].(string)
+			_, exists := f.files[name]
+			if last, ok := action["last_commit_id"].(string); ok && exists && last != f.changed[name] {
+				http.Error(w, `{"message":"You are attempting to update a file that has changed since you started editing it."}`, http.StatusBadRequest)
+				return
+			}
+			switch action["action"] {
+			case "create":
+				if exists {
+					http.Error(w, `{"message":"A file with this name already exists"}`, http.StatusBadRequest)
+					return
+				}
+				fallthrough
+			case "update":
+				content, _ := base64.StdEncoding.DecodeString(action["content"].(string))
+				f.files[name] = string(content)
+			case "chmod":
+				f.executable[name] = action["execute_filemode"].(bool)
+			case "delete":
+				delete(f.files, name)
+			}
+			f.changed[name] = sha
+		}
+		f.head = sha
+		writeFakeJSON(w, map[string]interface{}{"id": sha})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestGitLabDestination(url string) *DestinationConfig {
+	return &DestinationConfig{
+		Type:          DestinationGitLab,
+		APIURL:        url + "/api/v4",
+		Owner:         "group",
+		Repo:          "project",
+		Branch:        "main",
+		CommitMessage: "update",
+		Token:         "token",
+	}
+}
+
+func TestGitLabCommitterSetsExecutableMode(t *testing.T) {
+	wadge.RunTest(t, func() {
+		fake := newFakeGitLab(map[string]string{"run.sh": "#!/bin/sh\n"})
+		server := httptest.NewServer(fake)
+		defer server.Close()
+
+		_, err := CommitToGit(newGitLabCommitter(newTestGitLabDestination(server.URL)), &SettingsConfig{}, []FileChange{
+			{Path: "bin/new.sh", Content: []byte("#!/bin/sh\n"), Mode: FileModeExecutable},
+			{Path: "run.sh", Content: []byte("#!/bin/sh\n"), Mode: FileModeExecutable},
+		})
+		if err != nil {
+			t.Fatalf("CommitToGit failed: %s", err)
+		}
+		if !fake.executable["bin/new.sh"] || !fake.executable["run.sh"] {
+			t.Fatalf("expected both scripts to be executable, got %v", fake.executable)
+		}
+	})
+}
+
+func TestLoadConfigRejectsEmptyCommitsForGitLab(t *testing.T) {
+	t.Setenv("Q2GIT_CONFIG", "settings:\n  allow_empty_commits: true\ndestination:\n  type: gitlab\n")
+	if _, err := LoadConfig(); err == nil {
+		t.Fatalf("expected allow_empty_commits to be rejected for gitlab")
+	}
+
+	t.Setenv("Q2GIT_CONFIG", "settings:\n  allow_empty_commits: true\n")
+	if _, err := LoadConfig(); err != nil {
+		t.Fatalf("expected allow_empty_commits to be accepted for github, got %s", err)
+	}
+}
@@ -2,16 +2,21 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type queryResult struct {
 	Name        string          `json:"name"`
 	Description string          `json:"description,omitempty"`
 	Result      json.RawMessage `json:"result"`
+
+	query QueryConfig
 }
 
 func runQueries(config *Config, queryName string) ([]queryResult, error) {
@@ -20,7 +25,11 @@ func runQueries(config *Config, queryName string) ([]queryResult, error) {
 		if queryName != "" && q.Name != queryName {
 			continue
 		}
-		data, err := FetchData(&config.Source, q.URL)
+		source, err := querySource(config, q)
+		if err != nil {
+			return nil, fmt.Errorf("query '%s': %w", q.Name, err)
+		}
+		data, err := FetchData(source, q.URL)
 		if err != nil {
 			return nil, fmt.Errorf("query '%s': failed to fetch data: %w", q.Name, err)
 		}
@@ -28,7 +37,7 @@ func runQueries(config *Config, queryName string) ([]queryResult, error) {
 		if err != nil {
 			return nil, fmt.Errorf("query '%s' failed: %w", q.Name, err)
 		}
-		results = append(results, queryResult{Name: q.Name, Description: q.Description, Result: json.RawMessage(result)})
+		results = append(results, queryResult{Name: q.Name, Description: q.Description, Result: json.RawMessage(result), query: q})
 	}
 	return results, nil
 }
@@ -80,13 +89,19 @@ func HandleExecuteQuery(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary Commit query results to git
-// @Description Fetches data, executes JQ queries, and commits results to the configured git repository
+// @Description Fetches data, executes JQ queries, and commits results to the configured git repositories. The response lists one entry per destination under commits; with a single destination it also keeps the top-level repo, branch and path fields. Destinations are committed in turn, so an error response lists the commits already pushed before the failure under commits.
 // @Tags query
 // @Router /api/commit [post]
 // @Param query query string false "Filter by query name"
+// @Param dry_run query boolean false "Return the would-be file contents and diff without committing"
 // @Success 200 {object} object "Commit success message"
 // @Failure 400 {object} object "Bad request"
+// @Failure 404 {object} object "Destination repository or branch not found"
+// @Failure 409 {object} object "Branch kept moving or conflicting change"
+// @Failure 422 {object} object "Rejected by the git host"
+// @Failure 429 {object} object "Rate limited by the git host"
 // @Failure 500 {object} object "Internal server error"
+// @Failure 502 {object} object "Git host failed or rejected the credentials"
 // @Produce json
 func HandleCommit(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -111,22 +126,40 @@ func HandleCommit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var combined []byte
-	for _, res := range results {
-		chunk := []byte(res.Result)
-		var unquoted string
-		if err := json.Unmarshal(chunk, &unquoted); err == nil {
-			chunk = []byte(unquoted)
-		}
-		combined = append(combined, chunk...)
+	runID := newRunID(time.Now())
+	batches, err := groupByDestination(config, results, runID)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid destination", err.Error())
+		return
 	}
 
-	if err := CommitToGit(&config.Destination, &config.Settings, combined); err != nil {
-		writeJSONError(w, http.StatusInternalServerError, "Failed to commit to git", err.Error())
+	if r.URL.Query().Get("dry_run") == "true" {
+		previews := make([]*CommitPreview, 0, len(batches))
+		for i, batch := range batches {
+			target := fmt.Sprintf("%s/%s@%s", batch.Destination.Owner, batch.Destination.Repo, batch.Destination.Branch)
+			preview, err := previewBatch(&batches[i])
+			if err != nil {
+				writeGitError(w, "Failed to read from git", target, err)
+				return
+			}
+			previews = append(previews, preview)
+		}
+		writeDryRun(w, runID, batches, previews)
 		return
 	}
 
-	writeCommitSuccess(w, config)
+	commits := make([]*CommitResult, 0, len(batches))
+	for i, batch := range batches {
+		target := fmt.Sprintf("%s/%s@%s", batch.Destination.Owner, batch.Destination.Repo, batch.Destination.Branch)
+		commit, err := writeBatch(&config.Settings, &batches[i])
+		if err != nil {
+			writeCommitFailure(w, runID, batches[:i], commits, target, err)
+			return
+		}
+		commits = append(commits, commit)
+	}
+
+	writeCommitSuccess(w, runID, batches, commits)
 }
 
 func writeJSONError(w http.ResponseWriter, status int, error, message string) {
@@ -139,20 +172,169 @@ func writeJSONError(w http.ResponseWriter, status int, error, message string) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// writeGitError reports a failed git operation with a status that reflects
+// the cause, and the details of the upstream API error if there is one.
+func writeGitError(w http.ResponseWriter, title, target string, err error) {
+	status, response := gitErrorResponse(w, title, target, err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// writeCommitFailure reports a batch that failed to commit, together with
+// the batches committed before it, which are already pushed.
+func writeCommitFailure(w http.ResponseWriter, runID string, batches []commitBatch, results []*CommitResult, target string, err error) {
+	status, response := gitErrorResponse(w, "Failed to commit to git", target, err)
+	response["run_id"] = runID
+	response["commits"] = commitEntries(batches, results)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// gitErrorResponse builds the error body for err and passes Retry-After on
+// to w when the host asks to wait.
+func gitErrorResponse(w http.ResponseWriter, title, target string, err error) (int, map[string]interface{}) {
+	response := map[string]interface{}{
+		"error":   title,
+		"message": fmt.Sprintf("%s: %s", target, err),
+	}
+	status := gitErrorStatus(err)
+
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		response["kind"] = apiErr.Kind().Error()
+		response["upstream_status"] = strconv.Itoa(apiErr.StatusCode)
+		if id := apiErr.RequestID(); id != "" {
+			response["request_id"] = id
+		}
+		if doc := apiErr.DocumentationURL(); doc != "" {
+			response["documentation_url"] = doc
+		}
+		if retryAfter := apiErr.Header.Get("Retry-After"); status == http.StatusTooManyRequests && retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+	} else if errors.Is(err, errBranchMoved) {
+		response["kind"] = errConflict.Error()
+	}
+	return status, response
+}
+
+// gitErrorStatus maps git errors to response statuses. Credential problems
+// are the service's own configuration, so they surface as 502 rather than
+// as 401/403 towards the caller.
+func gitErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errBranchMoved), errors.Is(err, errConflict):
+		return http.StatusConflict
+	case errors.Is(err, errNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errValidation):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, errRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, errUnauthorized), errors.Is(err, errForbidden), errors.Is(err, errUpstream):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func writeJSONRaw(w http.ResponseWriter, data []byte) {
 	w.Header().Set("Content-Type", "application/json")
 	_, _ = w.Write(data)
 }
 
-func writeCommitSuccess(w http.ResponseWriter, config *Config) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+func writeCommitSuccess(w http.ResponseWriter, runID string, batches []commitBatch, results []*CommitResult) {
+	unchanged := true
+	for _, result := range results {
+		if !result.Unchanged {
+			unchanged = false
+		}
+	}
+
+	response := map[string]interface{}{
 		"status":  "success",
 		"message": "Query executed and results committed to git",
-		"repo":    fmt.Sprintf("%s/%s", config.Destination.Owner, config.Destination.Repo),
-		"branch":  config.Destination.Branch,
-		"path":    config.Destination.OutputPath,
-	})
+		"run_id":  runID,
+		"commits": commitEntries(batches, results),
+	}
+	if unchanged {
+		response["status"] = "unchanged"
+		response["message"] = "Query results match the repository content, nothing committed"
+	}
+	// Responses for a single destination keep the fields they had before
+	// commits was introduced.
+	if len(batches) == 1 {
+		response["repo"] = fmt.Sprintf("%s/%s", batches[0].Destination.Owner, batches[0].Destination.Repo)
+		response["branch"] = results[0].Branch
+		response["path"] = batches[0].Destination.OutputPath
+		if files := results[0].Files; len(files) == 1 {
+			response["path"] = files[0].Path
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// commitEntries describes the commit made for each batch.
+func commitEntries(batches []commitBatch, results []*CommitResult) []map[string]interface{} {
+	commits := make([]map[string]interface{}, 0, len(results))
+	for i, batch := range batches[:len(results)] {
+		status := "committed"
+		if results[i].Unchanged {
+			status = "unchanged"
+		}
+		commit := map[string]interface{}{
+			"repo":       fmt.Sprintf("%s/%s", batch.Destination.Owner, batch.Destination.Repo),
+			"branch":     results[i].Branch,
+			"status":     status,
+			"sha":        results[i].SHA,
+			"parent_sha": results[i].ParentSHA,
+			"url":        results[i].URL,
+			"files":      results[i].Files,
+			"retries":    results[i].Retries,
+		}
+		if results[i].PullRequest != nil {
+			commit["pull_request"] = results[i].PullRequest
+		}
+		if len(results[i].Warnings) > 0 {
+			commit["warnings"] = results[i].Warnings
+		}
+		commits = append(commits, commit)
+	}
+	return commits
+}
+
+func writeDryRun(w http.ResponseWriter, runID string, batches []commitBatch, previews []*CommitPreview) {
+	commits := make([]map[string]interface{}, 0, len(batches))
+	for i, batch := range batches {
+		status := "unchanged"
+		for _, file := range previews[i].Files {
+			if file.Status == "changed" {
+				status = "changed"
+			}
+		}
+		commits = append(commits, map[string]interface{}{
+			"repo":           fmt.Sprintf("%s/%s", batch.Destination.Owner, batch.Destination.Repo),
+			"branch":         batch.Destination.Branch,
+			"status":         status,
+			"base_sha":       previews[i].Base,
+			"commit_message": batch.Destination.CommitMessage,
+			"files":          previews[i].Files,
+		})
+	}
+
+	response := map[string]interface{}{
+		"status":  "dry_run",
+		"message": "Dry run, nothing committed",
+		"run_id":  runID,
+		"commits": commits,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 
 // @Summary Root endpoint
@@ -183,7 +365,7 @@ func HandleHealth(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary Service status
-// @Description Returns detailed service status including version and available endpoints
+// @Description Returns detailed service status including version, available endpoints and, for GitHub destinations, the remaining API quota
 // @Tags monitoring
 // @Router /api/status [get]
 // @Success 200 {object} object "Service status"
@@ -202,9 +384,31 @@ func HandleStatus(w http.ResponseWriter, r *http.Request) {
 			"/api/execute",
 		},
 	}
+	if quota := destinationQuota(); quota != nil {
+		response["github_rate_limit"] = quota
+	}
 	json.NewEncoder(w).Encode(response)
 }
 
+// destinationQuota reports the GitHub API quota left for the configured
+// destination, or nil when there is no GitHub destination to ask.
+func destinationQuota() interface{} {
+	config, err := LoadConfig()
+	if err != nil {
+		return nil
+	}
+	cfg := &config.Destination
+	if cfg.Type != DestinationGitHub || (cfg.Token == "" && !cfg.GitHubApp.configured()) {
+		return nil
+	}
+
+	quotas, err := githubRateLimits(cfg)
+	if err != nil {
+		return map[string]string{"error": err.Error()}
+	}
+	return quotas
+}
+
 // @Summary 404 handler
 // @Description Returns a 404 error for unmatched routes
 // @Tags general
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.wasmcloud.dev/component/net/wasihttp"
+)
+
+func newHTTPClient() *http.Client {
+	return &http.Client{
+		Transport: &wasihttp.Transport{
+			ConnectTimeout: 30 * time.Second,
+		},
+	}
+}
+
+// apiError is a non-2xx response from a destination API. It matches one of
+// the error kinds below with errors.Is.
+type apiError struct {
+	StatusCode int
+	Body       string
+	Header     http.Header
+}
+
+// apiErrorKind classifies API errors by what the caller can do about them.
+type apiErrorKind struct {
+	name string
+}
+
+func (k *apiErrorKind) Error() string {
+	return k.name
+}
+
+var (
+	errNotFound     = &apiErrorKind{"not_found"}
+	errUnauthorized = &apiErrorKind{"unauthorized"}
+	errForbidden    = &apiErrorKind{"forbidden"}
+	errConflict     = &apiErrorKind{"conflict"}
+	errValidation   = &apiErrorKind{"validation"}
+	errRateLimited  = &apiErrorKind{"rate_limited"}
+	errUpstream     = &apiErrorKind{"upstream"}
+)
+
+func (e *apiError) Kind() *apiErrorKind {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return errNotFound
+	case http.StatusUnauthorized:
+		return errUnauthorized
+	case http.StatusForbidden:
+		if e.rateLimited() {
+			return errRateLimited
+		}
+		return errForbidden
+	case http.StatusConflict:
+		return errConflict
+	case http.StatusUnprocessableEntity, http.StatusBadRequest:
+		return errValidation
+	case http.StatusTooManyRequests:
+		return errRateLimited
+	default:
+		return errUpstream
+	}
+}
+
+func (e *apiError) Is(target error) bool {
+	return target == error(e.Kind())
+}
+
+func (e *apiError) rateLimited() bool {
+	return e.Header.Get("Retry-After") != "" ||
+		e.Header.Get("X-RateLimit-Remaining") == "0" ||
+		strings.Contains(strings.ToLower(e.Body), "rate limit")
+}
+
+// apiErrorBody is the error body of GitHub and of the APIs modelled on it.
+type apiErrorBody struct {
+	Message          string `json:"message"`
+	DocumentationURL string `json:"documentation_url"`
+	Errors           []struct {
+		Message string `json:"message"`
+		Code    string `json:"code"`
+		Field   string `json:"field"`
+	} `json:"errors"`
+}
+
+// Message returns the error message of the response body, with validation
+// details if there are any, or the raw body if it has no message.
+func (e *apiError) Message() string {
+	var body apiErrorBody
+	if json.Unmarshal([]byte(e.Body), &body) != nil || body.Message == "" {
+		return strings.TrimSpace(e.Body)
+	}
+
+	msg := body.Message
+	for _, detail := range body.Errors {
+		switch {
+		case detail.Message != "":
+			msg += "; " + detail.Message
+		case detail.Field != "":
+			msg += fmt.Sprintf("; %s %s", detail.Field, detail.Code)
+		}
+	}
+	return msg
+}
+
+// DocumentationURL links GitHub's documentation for the failed call.
+func (e *apiError) DocumentationURL() string {
+	var body apiErrorBody
+	_ = json.Unmarshal([]byte(e.Body), &body)
+	return body.DocumentationURL
+}
+
+// RequestID identifies the failed call in the API's logs.
+func (e *apiError) RequestID() string {
+	if id := e.Header.Get("X-GitHub-Request-Id"); id != "" {
+		return id
+	}
+	return e.Header.Get("X-Request-Id")
+}
+
+func (e *apiError) Error() string {
+	msg := fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Message())
+	if e.Kind() == errForbidden {
+		if accepted := e.Header.Get("X-Accepted-OAuth-Scopes"); accepted != "" {
+			msg += fmt.Sprintf(" (token scopes: %q, accepted: %q)", e.Header.Get("X-OAuth-Scopes"), accepted)
+		}
+	}
+	if id := e.RequestID(); id != "" {
+		msg += fmt.Sprintf(" [request %s]", id)
+	}
+	return msg
+}
+
+// jsonAPIRequest sends payload as JSON with the given headers and decodes a
+// 2xx response into response. Other statuses are returned as *apiError.
+func jsonAPIRequest(method, url string, headers map[string]string, payload, response interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		jsonData, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewBuffer(jsonData)
+		headers = withHeader(headers, "Content-Type", "application/json")
+	}
+
+	respBody, _, err := apiRequest(method, url, headers, body)
+	if err != nil {
+		return err
+	}
+
+	if response != nil && len(respBody) > 0 {
+		return json.Unmarshal(respBody, response)
+	}
+
+	return nil
+}
+
+// apiRequest sends body with the given headers and returns the raw body and
+// headers of a 2xx response. Other statuses are returned as *apiError.
+func apiRequest(method, url string, headers map[string]string, body io.Reader) ([]byte, http.Header, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := newHTTPClient().Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, nil, &apiError{StatusCode: resp.StatusCode, Body: string(respBody), Header: resp.Header}
+	}
+
+	return respBody, resp.Header, nil
+}
+
+// withHeader returns a copy of headers with key set to value.
+func withHeader(headers map[string]string, key, value string) map[string]string {
+	out := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		out[k] = v
+	}
+	out[key] = value
+	return out
+}
+
+// isNotFound reports whether err is a 404 API response.
+func isNotFound(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestAPIErrorKindsMapToResponseStatuses(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		kind    *apiErrorKind
+		status  int
+		message string
+	}{
+		{
+			name: "missing branch",
+			err: fmt.Errorf("failed to get branch ref: %w", &apiError{
+				StatusCode: http.StatusNotFound,
+				Body:       `{"message":"Not Found","documentation_url":"https://docs.github.com/rest/git/refs#get-a-reference"}`,
+				Header:     http.Header{"X-Github-Request-Id": {"C0DE:1234"}},
+			}),
+			kind:    errNotFound,
+			status:  http.StatusNotFound,
+			message: "failed to get branch ref: HTTP 404: Not Found [request C0DE:1234]",
+		},
+		{
+			name:    "bad credentials",
+			err:     &apiError{StatusCode: http.StatusUnauthorized, Body: `{"message":"Bad credentials"}`},
+			kind:    errUnauthorized,
+			status:  http.StatusBadGateway,
+			message: "HTTP 401: Bad credentials",
+		},
+		{
+			name: "missing scopes",
+			err: &apiError{
+				StatusCode: http.StatusForbidden,
+				Body:       `{"message":"Resource not accessible by personal access token"}`,
+				Header:     http.Header{"X-Oauth-Scopes": {"read:org"}, "X-Accepted-Oauth-Scopes": {"repo"}},
+			},
+			kind:    errForbidden,
+			status:  http.StatusBadGateway,
+			message: `HTTP 403: Resource not accessible by personal access token (token scopes: "read:org", accepted: "repo")`,
+		},
+		{
+			name: "rate limited",
+			err: &apiError{
+				StatusCode: http.StatusForbidden,
+				Body:       `{"message":"API rate limit exceeded"}`,
+				Header:     http.Header{"X-Ratelimit-Remaining": {"0"}},
+			},
+			kind:    errRateLimited,
+			status:  http.StatusTooManyRequests,
+			message: "HTTP 403: API rate limit exceeded",
+		},
+		{
+			name: "validation",
+			err: &apiError{
+				StatusCode: http.StatusUnprocessableEntity,
+				Body:       `{"message":"Validation Failed","errors":[{"message":"A pull request already exists for owner:q2git/power."}]}`,
+			},
+			kind:    errValidation,
+			status:  http.StatusUnprocessableEntity,
+			message: "HTTP 422: Validation Failed; A pull request already exists for owner:q2git/power.",
+		},
+		{
+			name:    "server error",
+			err:     &apiError{StatusCode: http.StatusBadGateway, Body: "<html>Bad Gateway</html>"},
+			kind:    errUpstream,
+			status:  http.StatusBadGateway,
+			message: "HTTP 502: <html>Bad Gateway</html>",
+		},
+		{
+			name:    "branch moved",
+			err:     fmt.Errorf("giving up after 5 attempts: %w", errBranchMoved),
+			status:  http.StatusConflict,
+			message: "giving up after 5 attempts: branch moved during commit",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.kind != nil && !errors.Is(tt.err, tt.kind) {
+				t.Fatalf("expected %v to be of kind %s", tt.err, tt.kind)
+			}
+			if got := gitErrorStatus(tt.err); got != tt.status {
+				t.Fatalf("want status %d, got %d", tt.status, got)
+			}
+			if got := tt.err.Error(); got != tt.message {
+				t.Fatalf("unexpected message:\nwant %s\ngot  %s", tt.message, got)
+			}
+		})
+	}
+}
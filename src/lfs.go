@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	lfsPointerVersion = "version https://git-lfs.github.com/spec/v1"
+	lfsMediaType      = "application/vnd.git-lfs+json"
+)
+
+// lfsObject identifies a file stored in Git LFS.
+type lfsObject struct {
+	OID  string `json:"oid"`
+	Size int64  `json:"size"`
+}
+
+func newLFSObject(content []byte) lfsObject {
+	sum := sha256.Sum256(content)
+	return lfsObject{OID: hex.EncodeToString(sum[:]), Size: int64(len(content))}
+}
+
+// pointer returns the pointer file committed in place of the object.
+func (o lfsObject) pointer() []byte {
+	return []byte(fmt.Sprintf("%s\noid sha256:%s\nsize %d\n", lfsPointerVersion, o.OID, o.Size))
+}
+
+// parseLFSPointer recognises a pointer file written by q2git or git-lfs.
+func parseLFSPointer(data []byte) (lfsObject, bool) {
+	if len(data) > 1024 || !bytes.HasPrefix(data, []byte(lfsPointerVersion+"\n")) {
+		return lfsObject{}, false
+	}
+	var obj lfsObject
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "oid":
+			obj.OID = strings.TrimPrefix(value, "sha256:")
+		case "size":
+			obj.Size, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	return obj, len(obj.OID) == 64 && obj.Size >= 0
+}
+
+// blobContent is what is committed to git for a change: a pointer file for
+// changes stored in LFS, the content otherwise.
+func blobContent(change FileChange) []byte {
+	if change.LFS {
+		return newLFSObject(change.Content).pointer()
+	}
+	return change.Content
+}
+
+// matches reports whether outputPath is stored in LFS. Patterns without a
+// slash match the file name, as in .gitattributes; others the whole path.
+func (c LFSConfig) matches(outputPath string) bool {
+	for _, pattern := range c.Paths {
+		name := outputPath
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(outputPath)
+		}
+		if ok, _ := path.Match(strings.TrimPrefix(pattern, "/"), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// lfsEndpoint returns the LFS server of a GitHub repository.
+func lfsEndpoint(cfg *DestinationConfig) string {
+	if cfg.LFS.URL != "" {
+		return strings.TrimRight(cfg.LFS.URL, "/")
+	}
+	return fmt.Sprintf("%s/%s/%s.git/info/lfs", githubWebURL(cfg), cfg.Owner, cfg.Repo)
+}
+
+// lfsAction is one step of a transfer, such as the upload of an object.
+type lfsAction struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header"`
+}
+
+type lfsBatchObject struct {
+	lfsObject
+	Actions map[string]lfsAction `json:"actions"`
+	Error   *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// lfsBatch asks the LFS server how to upload or download objects, using the
+// basic transfer adapter.
+func lfsBatch(cfg *DestinationConfig, operation string, objects []lfsObject) ([]lfsBatchObject, error) {
+	token, err := githubToken(cfg)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"operation": operation,
+		"transfers": []string{"basic"},
+		"ref":       map[string]string{"name": "refs/heads/" + cfg.Branch},
+		"objects":   objects,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	headers := map[string]string{
+		"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte("x-access-token:"+token)),
+		"Accept":        lfsMediaType,
+		"Content-Type":  lfsMediaType,
+	}
+	respBody, _, err := apiRequest("POST", lfsEndpoint(cfg)+"/objects/batch", headers, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to request LFS %s: %w", operation, err)
+	}
+
+	var batch struct {
+		Objects []lfsBatchObject `json:"objects"`
+	}
+	if err := json.Unmarshal(respBody, &batch); err != nil {
+		return nil, fmt.Errorf("failed to decode LFS batch response: %w", err)
+	}
+	for _, obj := range batch.Objects {
+		if obj.Error != nil {
+			return nil, fmt.Errorf("LFS object %s: %s (%d)", obj.OID, obj.Error.Message, obj.Error.Code)
+		}
+	}
+	return batch.Objects, nil
+}
+
+// uploadLFSObjects stores contents on the LFS server. Objects the server
+// already has come back without an upload action and are skipped. Uploads
+// and verifications are sent once through apiRequest rather than
+// githubAPIRequest, whose retries would send whole objects again.
+func uploadLFSObjects(cfg *DestinationConfig, contents [][]byte) error {
+	byOID := make(map[string][]byte, len(contents))
+	objects := make([]lfsObject, 0, len(contents))
+	for _, content := range contents {
+		obj := newLFSObject(content)
+		if _, ok := byOID[obj.OID]; !ok {
+			byOID[obj.OID] = content
+			objects = append(objects, obj)
+		}
+	}
+
+	batch, err := lfsBatch(cfg, "upload", objects)
+	if err != nil {
+		return err
+	}
+	for _, obj := range batch {
+		upload, ok := obj.Actions["upload"]
+		if !ok {
+			continue
+		}
+		content, ok := byOID[obj.OID]
+		if !ok {
+			return fmt.Errorf("LFS server returned unknown object %s", obj.OID)
+		}
+
+		headers := withHeader(upload.Header, "Content-Type", "application/octet-stream")
+		if _, _, err := apiRequest("PUT", upload.Href, headers, bytes.NewReader(content)); err != nil {
+			return fmt.Errorf("failed to upload LFS object %s: %w", obj.OID, err)
+		}
+		if verify, ok := obj.Actions["verify"]; ok {
+			body, _ := json.Marshal(obj.lfsObject)
+			headers := withHeader(verify.Header, "Content-Type", lfsMediaType)
+			if _, _, err := apiRequest("POST", verify.Href, headers, bytes.NewReader(body)); err != nil {
+				return fmt.Errorf("failed to verify LFS object %s: %w", obj.OID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// downloadLFSObject fetches an object and checks it against its pointer.
+func downloadLFSObject(cfg *DestinationConfig, obj lfsObject) ([]byte, error) {
+	batch, err := lfsBatch(cfg, "download", []lfsObject{obj})
+	if err != nil {
+		return nil, err
+	}
+	if len(batch) != 1 || batch[0].Actions["download"].Href == "" {
+		return nil, fmt.Errorf("LFS server has no download for object %s", obj.OID)
+	}
+
+	download := batch[0].Actions["download"]
+	content, _, err := apiRequest("GET", download.Href, download.Header, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download LFS object %s: %w", obj.OID, err)
+	}
+	if err := checkLFSObject(obj, content); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// checkLFSObject tells apart downloads that were cut short, that carry extra
+// bytes and whose content was altered.
+func checkLFSObject(obj lfsObject, content []byte) error {
+	got := newLFSObject(content)
+	switch {
+	case got.Size < obj.Size:
+		return fmt.Errorf("LFS object %s is truncated: got %d of %d bytes", obj.OID, got.Size, obj.Size)
+	case got.Size > obj.Size:
+		return fmt.Errorf("LFS object %s is too long: got %d bytes, its pointer says %d", obj.OID, got.Size, obj.Size)
+	case got.OID != obj.OID:
+		return fmt.Errorf("LFS object %s was altered: its %d bytes hash to %s", obj.OID, got.Size, got.OID)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.wasmcloud.dev/wadge"
+)
+
+// fakeLFS is a stand-in Git LFS server implementing the batch API with the
+// basic transfer adapter.
+type fakeLFS struct {
+	mu      sync.Mutex
+	url     string
+	objects map[string][]byte
+	uploads int
+	// failUploads answers uploads with 502 Bad Gateway.
+	failUploads bool
+	// serve changes the content of downloads.
+	serve func(content []byte) []byte
+}
+
+func (f *fakeLFS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/objects/batch":
+		var req struct {
+			Operation string      `json:"operation"`
+			Objects   []lfsObject `json:"objects"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || r.Header.Get("Accept") != lfsMediaType {
+			http.Error(w, `{"message":"bad batch request"}`, http.StatusBadRequest)
+			return
+		}
+		objects := make([]map[string]interface{}, 0, len(req.Objects))
+		for _, obj := range req.Objects {
+			item := map[string]interface{}{"oid": obj.OID, "size": obj.Size}
+			_, stored := f.objects[obj.OID]
+			switch {
+			case req.Operation == "upload" && !stored:
+				item["actions"] = map[string]interface{}{
+					"upload": map[string]interface{}{"href": f.url + "/objects/" + obj.OID, "header": map[string]string{"X-Upload": "1"}},
+					"verify": map[string]interface{}{"href": f.url + "/verify"},
+				}
+			case req.Operation == "download" && stored:
+				item["actions"] = map[string]interface{}{
+					"download": map[string]interface{}{"href": f.url + "/objects/" + obj.OID},
+				}
+			case req.Operation == "download":
+				item["error"] = map[string]interface{}{"code": 404, "message": "Object does not exist"}
+			}
+			objects = append(objects, item)
+		}
+		w.Header().Set("Content-Type", lfsMediaType)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"transfer": "basic", "objects": objects})
+
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/objects/"):
+		f.uploads++
+		if f.failUploads {
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		content, _ := io.ReadAll(r.Body)
+		oid := strings.TrimPrefix(r.URL.Path, "/objects/")
+		if r.Header.Get("X-Upload") != "1" || newLFSObject(content).OID != oid {
+			http.Error(w, "rejected upload", http.StatusBadRequest)
+			return
+		}
+		f.objects[oid] = content
+
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/objects/"):
+		content := f.objects[strings.TrimPrefix(r.URL.Path, "/objects/")]
+		if f.serve != nil {
+			content = f.serve(content)
+		}
+		_, _ = w.Write(content)
+
+	case r.Method == http.MethodPost && r.URL.Path == "/verify":
+		var obj lfsObject
+		_ = json.NewDecoder(r.Body).Decode(&obj)
+		if _, ok := f.objects[obj.OID]; !ok {
+			http.Error(w, `{"message":"object not uploaded"}`, http.StatusNotFound)
+		}
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestCommitToGitStoresLFSContentAsPointer(t *testing.T) {
+	wadge.RunTest(t, func() {
+		fake := newFakeGitHub(map[string]string{})
+		server := httptest.NewServer(fake)
+		defer server.Close()
+		lfs := &fakeLFS{objects: map[string][]byte{}}
+		lfsServer := httptest.NewServer(lfs)
+		defer lfsServer.Close()
+		lfs.url = lfsServer.URL
+
+		cfg := newTestDestination(server.URL)
+		cfg.LFS.URL = lfsServer.URL
+
+		first := []byte(`{"snapshot":1}` + "\n")
+		if _, err := CommitToGit(newGitHubCommitter(cfg), &SettingsConfig{}, []FileChange{
+			{Path: "snapshots/day.ndjson", Content: first, LFS: true},
+		}); err != nil {
+			t.Fatalf("CommitToGit failed: %s", err)
+		}
+		if got, _ := fake.file("main", "snapshots/day.ndjson"); got != string(newLFSObject(first).pointer()) {
+			t.Fatalf("expected a pointer file in git, got %q", got)
+		}
+		if string(lfs.objects[newLFSObject(first).OID]) != string(first) {
+			t.Fatalf("expected the content to be uploaded to LFS")
+		}
+
+		// Merges read the current content back from LFS.
+		second := []byte(`{"snapshot":2}` + "\n")
+		result, err := CommitToGit(newGitHubCommitter(cfg), &SettingsConfig{}, []FileChange{
+			{Path: "snapshots/day.ndjson", Content: second, WriteMode: WriteModeNDJSONAppend, LFS: true},
+		})
+		if err != nil {
+			t.Fatalf("CommitToGit failed: %s", err)
+		}
+		merged := append(append([]byte{}, first...), second...)
+		if string(lfs.objects[newLFSObject(merged).OID]) != string(merged) || result.Files[0].Bytes != len(merged) {
+			t.Fatalf("expected the merged content in LFS, got objects %v", lfs.objects)
+		}
+
+		// Identical content is detected through the pointer, without uploads.
+		uploads := lfs.uploads
+		result, err = CommitToGit(newGitHubCommitter(cfg), &SettingsConfig{}, []FileChange{
+			{Path: "snapshots/day.ndjson", Content: merged, LFS: true},
+		})
+		if err != nil {
+			t.Fatalf("CommitToGit failed: %s", err)
+		}
+		if !result.Unchanged || lfs.uploads != uploads {
+			t.Fatalf("expected unchanged LFS content not to be committed or uploaded")
+		}
+	})
+}
+
+func TestUploadLFSObjectsSendsEachObjectOnce(t *testing.T) {
+	wadge.RunTest(t, func() {
+		lfs := &fakeLFS{objects: map[string][]byte{}, failUploads: true}
+		server := httptest.NewServer(lfs)
+		defer server.Close()
+		lfs.url = server.URL
+
+		cfg := newTestDestination(server.URL)
+		cfg.LFS.URL = server.URL
+		if err := uploadLFSObjects(cfg, [][]byte{[]byte("snapshot")}); err == nil || !strings.Contains(err.Error(), "failed to upload LFS object") {
+			t.Fatalf("expected the upload to fail, got %v", err)
+		}
+		if lfs.uploads != 1 {
+			t.Fatalf("expected the object to be sent once, got %d uploads", lfs.uploads)
+		}
+	})
+}
+
+func TestDownloadLFSObjectReportsMismatches(t *testing.T) {
+	content := []byte(`{"snapshot":1}` + "\n")
+	obj := newLFSObject(content)
+	tests := []struct {
+		name  string
+		serve func([]byte) []byte
+		want  string
+	}{
+		{"truncated", func(b []byte) []byte { return b[:4] }, "is truncated: got 4 of 15 bytes"},
+		{"extended", func(b []byte) []byte { return append(b, '\n') }, "is too long: got 16 bytes, its pointer says 15"},
+		{"altered", func(b []byte) []byte { return []byte(strings.Replace(string(b), "1", "2", 1)) }, "was altered"},
+	}
+
+	wadge.RunTest(t, func() {
+		lfs := &fakeLFS{objects: map[string][]byte{obj.OID: content}}
+		server := httptest.NewServer(lfs)
+		defer server.Close()
+		lfs.url = server.URL
+
+		cfg := newTestDestination(server.URL)
+		cfg.LFS.URL = server.URL
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				lfs.serve = tt.serve
+				if _, err := downloadLFSObject(cfg, obj); err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Fatalf("expected %q, got %v", tt.want, err)
+				}
+			})
+		}
+	})
+}
+
+func TestLFSPathPatterns(t *testing.T) {
+	cfg := LFSConfig{Paths: []string{"*.parquet", "snapshots/*.json"}}
+	for path, want := range map[string]bool{
+		"data.parquet":           true,
+		"deep/dir/data.parquet":  true,
+		"snapshots/day.json":     true,
+		"other/snapshots/x.json": false,
+		"data.json":              false,
+	} {
+		if got := cfg.matches(path); got != want {
+			t.Errorf("matches(%q) = %t, want %t", path, got, want)
+		}
+	}
+}
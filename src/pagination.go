@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Pagination strategies accepted in PaginationConfig.Type.
+const (
+	PaginationLink   = "link"
+	PaginationPage   = "page"
+	PaginationOffset = "offset"
+	PaginationCursor = "cursor"
+)
+
+const defaultMaxPages = 10
+
+func (p *PaginationConfig) validate() error {
+	if p == nil {
+		return nil
+	}
+	switch p.Type {
+	case PaginationLink, PaginationPage, PaginationOffset:
+	case PaginationCursor:
+		if p.Cursor == "" {
+			return fmt.Errorf("cursor pagination needs a cursor jq path")
+		}
+		if _, err := compileExpression(p.Cursor); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported pagination type '%s'", p.Type)
+	}
+	if p.MaxPages < 0 || p.Size < 0 {
+		return fmt.Errorf("pagination max_pages and size must not be negative")
+	}
+	if p.Items != "" {
+		if _, err := compileExpression(p.Items); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// param returns the URL parameter carrying the page position.
+func (p *PaginationConfig) param() string {
+	if p.Param != "" {
+		return p.Param
+	}
+	return p.Type
+}
+
+func (p *PaginationConfig) sizeParam() string {
+	switch {
+	case p.SizeParam != "":
+		return p.SizeParam
+	case p.Type == PaginationPage, p.Type == PaginationLink:
+		return "per_page"
+	default:
+		return "limit"
+	}
+}
+
+// fetchPages follows the source's pagination from rawURL and returns the
+// records of all pages as one JSON array. It fails rather than return a
+// partial result if more pages follow the last one max_pages allows; when
+// that page does not tell, e.g. a full page of a page counter, the next page
+// is fetched to check that it is empty.
+func fetchPages(cfg *SourceConfig, rawURL string) ([]byte, error) {
+	p := cfg.Pagination
+	var items, cursor *jqExpression
+	var err error
+	if p.Items != "" {
+		if items, err = compileExpression(p.Items); err != nil {
+			return nil, err
+		}
+	}
+	if p.Type == PaginationCursor {
+		if cursor, err = compileExpression(p.Cursor); err != nil {
+			return nil, err
+		}
+	}
+	maxPages := p.MaxPages
+	if maxPages == 0 {
+		maxPages = defaultMaxPages
+	}
+	if p.Size > 0 {
+		if rawURL, err = withQueryParam(rawURL, p.sizeParam(), strconv.Itoa(p.Size)); err != nil {
+			return nil, err
+		}
+	}
+
+	records := []interface{}{}
+	pageURL, pageCfg := rawURL, cfg
+	page, offset := p.Start, 0
+	if page == 0 {
+		page = 1
+	}
+	for n := 0; ; n++ {
+		switch p.Type {
+		case PaginationPage:
+			pageURL, err = withQueryParam(rawURL, p.param(), strconv.Itoa(page+n))
+		case PaginationOffset:
+			pageURL, err = withQueryParam(rawURL, p.param(), strconv.Itoa(offset))
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		data, header, err := fetchPage(pageCfg, pageURL)
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", n+1, err)
+		}
+		// Numbers are kept as written, so that large IDs and cursors survive.
+		var doc interface{}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("page %d: failed to parse JSON: %w", n+1, err)
+		}
+		pageRecords, err := pageItems(items, doc)
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", n+1, err)
+		}
+		if n == maxPages {
+			// One page past the limit, fetched only to tell whether the
+			// limit cut off any records.
+			if len(pageRecords) > 0 {
+				return nil, fmt.Errorf("more pages follow the max_pages limit of %d, raise pagination.max_pages to fetch them", maxPages)
+			}
+			return json.Marshal(records)
+		}
+		records = append(records, pageRecords...)
+
+		last := len(pageRecords) == 0 || (p.Size > 0 && len(pageRecords) < p.Size)
+		switch p.Type {
+		case PaginationLink:
+			next := nextLink(header)
+			if next == "" {
+				return json.Marshal(records)
+			}
+			if pageURL, err = resolveURL(pageURL, next); err != nil {
+				return nil, err
+			}
+		case PaginationPage:
+			if last {
+				return json.Marshal(records)
+			}
+		case PaginationOffset:
+			if last {
+				return json.Marshal(records)
+			}
+			offset += len(pageRecords)
+		case PaginationCursor:
+			value, err := cursor.Eval(doc)
+			if err != nil {
+				return nil, fmt.Errorf("page %d: %w", n+1, err)
+			}
+			if value == nil || value == "" {
+				return json.Marshal(records)
+			}
+			if pageURL, pageCfg, err = cursorPage(cfg, rawURL, cursorString(value)); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// pageItems returns the records of a page: the elements of the array items
+// selects, or of the page itself.
+func pageItems(items *jqExpression, doc interface{}) ([]interface{}, error) {
+	if items != nil {
+		v, err := items.Eval(doc)
+		if err != nil {
+			return nil, err
+		}
+		doc = v
+	}
+	switch v := doc.(type) {
+	case []interface{}:
+		return v, nil
+	case nil:
+		return nil, nil
+	default:
+		if items == nil {
+			return nil, fmt.Errorf("page is not a JSON array; set pagination.items to the path of its records")
+		}
+		return []interface{}{v}, nil
+	}
+}
+
+// cursorString formats a cursor taken from a page. Numbers are written out in
+// full rather than in exponent notation.
+func cursorString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case json.Number:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// cursorPage returns the request for the page at cursor: a URL parameter,
+// or a variable of GraphQL queries.
+func cursorPage(cfg *SourceConfig, rawURL, cursor string) (string, *SourceConfig, error) {
+	if cfg.GraphQL == nil {
+		pageURL, err := withQueryParam(rawURL, cfg.Pagination.param(), cursor)
+		return pageURL, cfg, err
+	}
+	page := *cfg
+	graphQL := *cfg.GraphQL
+	graphQL.Variables = make(map[string]interface{}, len(cfg.GraphQL.Variables)+1)
+	for key, value := range cfg.GraphQL.Variables {
+		graphQL.Variables[key] = value
+	}
+	graphQL.Variables[cfg.Pagination.param()] = cursor
+	page.GraphQL = &graphQL
+	return rawURL, &page, nil
+}
+
+// nextLink returns the rel="next" target of a Link header (RFC 8288).
+func nextLink(header http.Header) string {
+	for _, value := range header.Values("Link") {
+		for _, link := range strings.Split(value, ",") {
+			target, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+			if !ok || !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, param := range strings.Split(params, ";") {
+				key, rel, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if strings.EqualFold(key, "rel") && containsField(strings.Trim(rel, `"`), "next") {
+					return strings.Trim(target, "<>")
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// containsField reports whether the space-separated list s contains field.
+func containsField(s, field string) bool {
+	for _, f := range strings.Fields(s) {
+		if strings.EqualFold(f, field) {
+			return true
+		}
+	}
+	return false
+}
+
+func resolveURL(base, ref string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL '%s': %w", base, err)
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid next page link '%s': %w", ref, err)
+	}
+	return b.ResolveReference(r).String(), nil
+}
+
+func withQueryParam(rawURL, key, value string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL '%s': %w", rawURL, err)
+	}
+	query := u.Query()
+	query.Set(key, value)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"go.wasmcloud.dev/wadge"
+)
+
+// paginatedItems serves the numbers 1 to 7 with every supported strategy.
+func paginatedItems(w http.ResponseWriter, r *http.Request) {
+	const total = 7
+	query := r.URL.Query()
+	size, _ := strconv.Atoi(query.Get("per_page"))
+	if size == 0 {
+		size = 3
+	}
+	start := 0
+	switch r.URL.Path {
+	case "/link", "/page":
+		page, _ := strconv.Atoi(query.Get("page"))
+		if page == 0 {
+			page = 1
+		}
+		start = (page - 1) * size
+		if r.URL.Path == "/link" && start+size < total {
+			w.Header().Set("Link", fmt.Sprintf(`</link?page=%d&per_page=%d>; rel="next"`, page+1, size))
+		}
+	case "/offset":
+		start, _ = strconv.Atoi(query.Get("offset"))
+		size, _ = strconv.Atoi(query.Get("limit"))
+	case "/cursor":
+		start, _ = strconv.Atoi(query.Get("after"))
+	}
+
+	items := []int{}
+	for i := start; i < start+size && i < total; i++ {
+		items = append(items, i+1)
+	}
+	switch r.URL.Path {
+	case "/link", "/page":
+		writeFakeJSON(w, items)
+	default:
+		var next interface{}
+		if start+size < total {
+			next = strconv.Itoa(start + size)
+		}
+		writeFakeJSON(w, map[string]interface{}{"data": items, "next": next})
+	}
+}
+
+func TestFetchDataMergesPages(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		pagination PaginationConfig
+		want       string
+		wantErr    bool
+	}{
+		{
+			name:       "link header",
+			path:       "/link",
+			pagination: PaginationConfig{Type: PaginationLink},
+			want:       "[1,2,3,4,5,6,7]",
+		},
+		{
+			name:       "link header with page size",
+			path:       "/link",
+			pagination: PaginationConfig{Type: PaginationLink, Size: 5, MaxPages: 2},
+			want:       "[1,2,3,4,5,6,7]",
+		},
+		{
+			name:       "page counter",
+			path:       "/page",
+			pagination: PaginationConfig{Type: PaginationPage, Size: 2},
+			want:       "[1,2,3,4,5,6,7]",
+		},
+		{
+			name:       "offset and limit",
+			path:       "/offset",
+			pagination: PaginationConfig{Type: PaginationOffset, Size: 3, Items: ".data"},
+			want:       "[1,2,3,4,5,6,7]",
+		},
+		{
+			name:       "cursor",
+			path:       "/cursor",
+			pagination: PaginationConfig{Type: PaginationCursor, Param: "after", Cursor: ".next", Items: ".data"},
+			want:       "[1,2,3,4,5,6,7]",
+		},
+		{
+			name:       "max pages cutting off a next link",
+			path:       "/link",
+			pagination: PaginationConfig{Type: PaginationLink, MaxPages: 2},
+			wantErr:    true,
+		},
+		{
+			name:       "max pages cutting off a cursor",
+			path:       "/cursor",
+			pagination: PaginationConfig{Type: PaginationCursor, Param: "after", Cursor: ".next", Items: ".data", MaxPages: 2},
+			wantErr:    true,
+		},
+		{
+			name:       "page counter without size ending at max pages",
+			path:       "/page",
+			pagination: PaginationConfig{Type: PaginationPage, MaxPages: 3},
+			want:       "[1,2,3,4,5,6,7]",
+		},
+		{
+			name:       "full pages filling max pages",
+			path:       "/offset",
+			pagination: PaginationConfig{Type: PaginationOffset, Size: 7, MaxPages: 1, Items: ".data"},
+			want:       "[1,2,3,4,5,6,7]",
+		},
+		{
+			name:       "max pages cutting off a full page",
+			path:       "/page",
+			pagination: PaginationConfig{Type: PaginationPage, Size: 2, MaxPages: 3},
+			wantErr:    true,
+		},
+	}
+
+	wadge.RunTest(t, func() {
+		server := httptest.NewServer(http.HandlerFunc(paginatedItems))
+		defer server.Close()
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				pagination := tt.pagination
+				data, err := FetchData(&SourceConfig{Method: http.MethodGet, Pagination: &pagination}, server.URL+tt.path)
+				if tt.wantErr {
+					if err == nil {
+						t.Fatalf("expected the cut off pages to fail, got %s", data)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("FetchData failed: %s", err)
+				}
+				if string(data) != tt.want {
+					t.Fatalf("want %s, got %s", tt.want, data)
+				}
+			})
+		}
+	})
+}
+
+func TestFetchDataPaginatesGraphQLCursors(t *testing.T) {
+	wadge.RunTest(t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				Variables map[string]interface{} `json:"variables"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			page := map[string]interface{}{"nodes": []string{"a", "b"}, "pageInfo": map[string]interface{}{"endCursor": "c1", "hasNextPage": true}}
+			if req.Variables["after"] == "c1" && req.Variables["owner"] == "q2git" {
+				page = map[string]interface{}{"nodes": []string{"c"}, "pageInfo": map[string]interface{}{"endCursor": "c2", "hasNextPage": false}}
+			}
+			writeFakeJSON(w, map[string]interface{}{"data": map[string]interface{}{"issues": page}})
+		}))
+		defer server.Close()
+
+		data, err := FetchData(&SourceConfig{
+			GraphQL: &GraphQLConfig{Query: "query($owner: String!, $after: String) { ... }", Variables: map[string]interface{}{"owner": "q2git"}},
+			Pagination: &PaginationConfig{
+				Type:   PaginationCursor,
+				Param:  "after",
+				Cursor: ".data.issues.pageInfo | if .hasNextPage then .endCursor else null end",
+				Items:  ".data.issues.nodes",
+			},
+		}, server.URL)
+		if err != nil {
+			t.Fatalf("FetchData failed: %s", err)
+		}
+		if string(data) != `["a","b","c"]` {
+			t.Fatalf("unexpected records: %s", data)
+		}
+	})
+}
+
+func TestCursorString(t *testing.T) {
+	for _, tt := range []struct {
+		value interface{}
+		want  string
+	}{
+		{"eyJpZCI6NDJ9", "eyJpZCI6NDJ9"},
+		{float64(1_000_000), "1000000"},
+		{1.5, "1.5"},
+		{json.Number("12345678901234567890"), "12345678901234567890"},
+	} {
+		if got := cursorString(tt.value); got != tt.want {
+			t.Errorf("cursorString(%v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestNextLink(t *testing.T) {
+	header := http.Header{}
+	header.Add("Link", `<https://api.github.com/repositories/1/issues?page=1>; rel="prev", <https://api.github.com/repositories/1/issues?page=3>; rel="next"`)
+	if got := nextLink(header); got != "https://api.github.com/repositories/1/issues?page=3" {
+		t.Fatalf("unexpected next link %q", got)
+	}
+	if got := nextLink(http.Header{"Link": {`<https://api.example.com/items?page=1>; rel="first"`}}); got != "" {
+		t.Fatalf("expected no next link, got %q", got)
+	}
+}
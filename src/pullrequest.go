@@ -0,0 +1,361 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Destination modes accepted in DestinationConfig.Mode.
+const (
+	DestinationModeDirect      = "direct"
+	DestinationModePullRequest = "pull_request"
+)
+
+const defaultPullRequestBranchPrefix = "q2git/"
+
+// PullRequestResult identifies the pull request a commit was proposed in.
+type PullRequestResult struct {
+	Number  int    `json:"number"`
+	URL     string `json:"url"`
+	Created bool   `json:"created"`
+}
+
+// commitViaPullRequest commits the batch to a dedicated branch and opens or
+// updates a pull request from it against the configured branch. A branch left
+// over from an already merged or closed pull request is reset to the base
+// branch first, so every pull request starts from the current base; see
+// preparePullRequestBranch for when that is refused.
+func commitViaPullRequest(settings *SettingsConfig, batch *commitBatch) (*CommitResult, error) {
+	base := &batch.Destination
+	if base.Type != "" && base.Type != DestinationGitHub {
+		return nil, fmt.Errorf("pull_request mode is only supported for github destinations")
+	}
+
+	head := *base
+	head.Branch = pullRequestBranch(batch)
+	c, err := newCommitter(&head)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, err := findOpenPullRequest(base, head.Branch)
+	if err != nil {
+		return nil, err
+	}
+
+	generated := base.PullRequest.Branch == ""
+	if err := preparePullRequestBranch(base, head.Branch, pr == nil, generated); err != nil {
+		return nil, err
+	}
+
+	result, err := CommitToGit(c, settings, batch.Changes)
+	if err != nil {
+		return nil, err
+	}
+	result.Branch = head.Branch
+	if result.Unchanged && pr == nil {
+		// Nothing to propose: remove the branch prepared for it, unless it
+		// was configured rather than named by q2git.
+		result.Branch = base.Branch
+		if generated {
+			if err := deleteBranchRef(&head); err != nil {
+				result.Warnings = append(result.Warnings, err.Error())
+			}
+		}
+		return result, nil
+	}
+
+	cfg := &base.PullRequest
+	title := cfg.Title
+	if title == "" {
+		title = fmt.Sprintf("q2git: update %s", strings.Join(batch.queryNames(nil), ", "))
+	}
+	body := pullRequestBody(batch, result.Changed)
+
+	created := pr == nil
+	if created {
+		pr, err = createPullRequest(base, head.Branch, title, body)
+	} else if !result.Unchanged {
+		err = updatePullRequest(base, pr.Number, title, body)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The commit and pull request exist at this point, so failures to
+	// decorate the pull request are reported as warnings.
+	if created {
+		if len(cfg.Labels) > 0 {
+			if err := addPullRequestLabels(base, pr.Number, cfg.Labels); err != nil {
+				result.Warnings = append(result.Warnings, err.Error())
+			}
+		}
+		if len(cfg.Reviewers) > 0 || len(cfg.TeamReviewers) > 0 {
+			if err := requestPullRequestReviewers(base, pr.Number, cfg.Reviewers, cfg.TeamReviewers); err != nil {
+				result.Warnings = append(result.Warnings, err.Error())
+			}
+		}
+		if cfg.AutoMerge {
+			if err := enablePullRequestAutoMerge(base, pr.NodeID, cfg.MergeMethod); err != nil {
+				result.Warnings = append(result.Warnings, err.Error())
+			}
+		}
+	}
+
+	result.PullRequest = &PullRequestResult{Number: pr.Number, URL: pr.HTMLURL, Created: created}
+	return result, nil
+}
+
+// pullRequestBranch names the branch commits are proposed from: the
+// configured branch, or the prefix followed by the query name when the batch
+// holds a single query, or by the base branch otherwise. Generated names are
+// made valid branch names.
+func pullRequestBranch(batch *commitBatch) string {
+	cfg := batch.Destination.PullRequest
+	if cfg.Branch != "" {
+		return cfg.Branch
+	}
+	prefix := cfg.BranchPrefix
+	if prefix == "" {
+		prefix = defaultPullRequestBranchPrefix
+	}
+	if names := batch.queryNames(nil); len(names) == 1 {
+		return sanitizeBranchName(prefix + names[0])
+	}
+	return sanitizeBranchName(prefix + batch.Destination.Branch)
+}
+
+// sanitizeBranchName replaces what git check-ref-format rejects: characters
+// other than letters, digits, "-", "_", "." and "/" become "-", and empty
+// components, components starting with "." or ending in ".lock", and ".."
+// are dropped.
+func sanitizeBranchName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '-', r == '_', r == '.', r == '/':
+			return r
+		default:
+			return '-'
+		}
+	}, name)
+	for strings.Contains(name, "..") {
+		name = strings.ReplaceAll(name, "..", ".")
+	}
+
+	var parts []string
+	for _, part := range strings.Split(name, "/") {
+		part = strings.TrimLeft(part, ".")
+		for strings.HasSuffix(part, ".lock") || strings.HasSuffix(part, ".") {
+			part = strings.TrimSuffix(strings.TrimSuffix(part, ".lock"), ".")
+		}
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		return strings.TrimSuffix(defaultPullRequestBranchPrefix, "/")
+	}
+	return strings.Join(parts, "/")
+}
+
+func pullRequestBody(batch *commitBatch, changed []string) string {
+	var sb strings.Builder
+	sb.WriteString("Automated update by q2git.\n\n")
+
+	if len(changed) == 0 {
+		sb.WriteString("No files changed in the latest run.\n")
+		return sb.String()
+	}
+
+	sb.WriteString("Queries changed in the latest run:\n\n")
+	for _, name := range batch.queryNames(changed) {
+		fmt.Fprintf(&sb, "- `%s`\n", name)
+	}
+	sb.WriteString("\nFiles:\n\n")
+	for _, path := range changed {
+		fmt.Fprintf(&sb, "- `%s`\n", path)
+	}
+	return sb.String()
+}
+
+// preparePullRequestBranch makes sure the head branch exists. When reset is
+// set, an existing branch is moved back to the tip of the base branch. A
+// configured branch is only reset if all its commits are on the base branch
+// already, so that a branch q2git did not name never loses work.
+func preparePullRequestBranch(base *DestinationConfig, branch string, reset, generated bool) error {
+	head := *base
+	head.Branch = branch
+
+	headSHA, err := getBranchRef(&head)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	exists := err == nil
+	if exists && !reset {
+		return nil
+	}
+
+	baseSHA, err := getBranchRef(base)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return createBranchRef(&head, baseSHA)
+	}
+	if !generated {
+		ahead, err := commitsAhead(base, baseSHA, headSHA)
+		if err != nil {
+			return err
+		}
+		if ahead > 0 {
+			return fmt.Errorf("%w: pull_request.branch '%s' has %d commits not on %s and no open pull request; q2git only resets branches it names itself, so delete the branch or choose another",
+				errConflict, branch, ahead, base.Branch)
+		}
+	}
+	return setBranchRef(&head, baseSHA, true)
+}
+
+// commitsAhead counts the commits reachable from head but not from base.
+func commitsAhead(cfg *DestinationConfig, base, head string) (int, error) {
+	url := fmt.Sprintf("%s/repos/%s/%s/compare/%s...%s",
+		cfg.APIURL, cfg.Owner, cfg.Repo, base, head)
+
+	var compareData struct {
+		AheadBy int `json:"ahead_by"`
+	}
+
+	if err := githubAPIRequest("GET", url, cfg, nil, &compareData); err != nil {
+		return 0, fmt.Errorf("failed to compare branches: %w", err)
+	}
+
+	return compareData.AheadBy, nil
+}
+
+type githubPullRequest struct {
+	Number  int    `json:"number"`
+	NodeID  string `json:"node_id"`
+	HTMLURL string `json:"html_url"`
+}
+
+func findOpenPullRequest(cfg *DestinationConfig, head string) (*githubPullRequest, error) {
+	url := fmt.Sprintf("%s/repos/%s/%s/pulls?state=open&head=%s&base=%s",
+		cfg.APIURL, cfg.Owner, cfg.Repo, url.QueryEscape(cfg.Owner+":"+head), url.QueryEscape(cfg.Branch))
+
+	var pulls []githubPullRequest
+	if err := githubAPIRequest("GET", url, cfg, nil, &pulls); err != nil {
+		return nil, fmt.Errorf("failed to list pull requests: %w", err)
+	}
+
+	if len(pulls) == 0 {
+		return nil, nil
+	}
+	return &pulls[0], nil
+}
+
+func createPullRequest(cfg *DestinationConfig, head, title, body string) (*githubPullRequest, error) {
+	url := fmt.Sprintf("%s/repos/%s/%s/pulls",
+		cfg.APIURL, cfg.Owner, cfg.Repo)
+
+	payload := map[string]string{
+		"title": title,
+		"head":  head,
+		"base":  cfg.Branch,
+		"body":  body,
+	}
+
+	var pr githubPullRequest
+	if err := githubAPIRequest("POST", url, cfg, payload, &pr); err != nil {
+		return nil, fmt.Errorf("failed to create pull request: %w", err)
+	}
+
+	return &pr, nil
+}
+
+func updatePullRequest(cfg *DestinationConfig, number int, title, body string) error {
+	url := fmt.Sprintf("%s/repos/%s/%s/pulls/%d",
+		cfg.APIURL, cfg.Owner, cfg.Repo, number)
+
+	payload := map[string]string{
+		"title": title,
+		"body":  body,
+	}
+
+	if err := githubAPIRequest("PATCH", url, cfg, payload, nil); err != nil {
+		return fmt.Errorf("failed to update pull request: %w", err)
+	}
+
+	return nil
+}
+
+func addPullRequestLabels(cfg *DestinationConfig, number int, labels []string) error {
+	url := fmt.Sprintf("%s/repos/%s/%s/issues/%d/labels",
+		cfg.APIURL, cfg.Owner, cfg.Repo, number)
+
+	payload := map[string][]string{"labels": labels}
+
+	if err := githubAPIRequest("POST", url, cfg, payload, nil); err != nil {
+		return fmt.Errorf("failed to add labels: %w", err)
+	}
+
+	return nil
+}
+
+func requestPullRequestReviewers(cfg *DestinationConfig, number int, reviewers, teamReviewers []string) error {
+	url := fmt.Sprintf("%s/repos/%s/%s/pulls/%d/requested_reviewers",
+		cfg.APIURL, cfg.Owner, cfg.Repo, number)
+
+	payload := map[string][]string{
+		"reviewers":      reviewers,
+		"team_reviewers": teamReviewers,
+	}
+
+	if err := githubAPIRequest("POST", url, cfg, payload, nil); err != nil {
+		return fmt.Errorf("failed to request reviewers: %w", err)
+	}
+
+	return nil
+}
+
+// enablePullRequestAutoMerge turns on auto-merge, which is only exposed
+// through the GraphQL API. The repository must allow auto-merge.
+func enablePullRequestAutoMerge(cfg *DestinationConfig, nodeID, mergeMethod string) error {
+	if mergeMethod == "" {
+		mergeMethod = "merge"
+	}
+
+	payload := map[string]interface{}{
+		"query": `mutation($id: ID!, $method: PullRequestMergeMethod!) {
+  enablePullRequestAutoMerge(input: {pullRequestId: $id, mergeMethod: $method}) { clientMutationId }
+}`,
+		"variables": map[string]string{
+			"id":     nodeID,
+			"method": strings.ToUpper(mergeMethod),
+		},
+	}
+
+	var response struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	if err := githubAPIRequest("POST", githubGraphQLURL(cfg), cfg, payload, &response); err != nil {
+		return fmt.Errorf("failed to enable auto-merge: %w", err)
+	}
+	if len(response.Errors) > 0 {
+		return fmt.Errorf("failed to enable auto-merge: %s", response.Errors[0].Message)
+	}
+
+	return nil
+}
+
+// githubGraphQLURL derives the GraphQL endpoint from the REST API URL, which
+// differs between github.com and GitHub Enterprise Server.
+func githubGraphQLURL(cfg *DestinationConfig) string {
+	if strings.HasSuffix(cfg.APIURL, "/api/v3") {
+		return strings.TrimSuffix(cfg.APIURL, "/v3") + "/graphql"
+	}
+	return cfg.APIURL + "/graphql"
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.wasmcloud.dev/wadge"
+)
+
+type fakePullRequest struct {
+	Number    int
+	Head      string
+	Base      string
+	Title     string
+	Body      string
+	Open      bool
+	Labels    []string
+	Reviewers []string
+	AutoMerge string
+}
+
+// fakePullRequests adds the pull request, label, reviewer and auto-merge
+// APIs to a fakeGitHub repository.
+type fakePullRequests struct {
+	*fakeGitHub
+
+	mu    sync.Mutex
+	pulls []*fakePullRequest
+	// failLabels makes adding labels fail, as for a missing label permission.
+	failLabels bool
+}
+
+func (f *fakePullRequests) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/repos/owner/repo/")
+	if !strings.HasPrefix(path, "pulls") && !strings.HasPrefix(path, "issues/") && r.URL.Path != "/graphql" {
+		f.fakeGitHub.ServeHTTP(w, r)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var body map[string]interface{}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	strs := func(key string) []string {
+		var out []string
+		for _, v := range body[key].([]interface{}) {
+			out = append(out, v.(string))
+		}
+		return out
+	}
+
+	switch {
+	case r.Method == http.MethodGet && path == "pulls":
+		open := []map[string]interface{}{}
+		for _, pr := range f.pulls {
+			if pr.Open && "owner:"+pr.Head == r.URL.Query().Get("head") && pr.Base == r.URL.Query().Get("base") {
+				open = append(open, f.pullJSON(pr))
+			}
+		}
+		writeFakeJSON(w, open)
+
+	case r.Method == http.MethodPost && path == "pulls":
+		pr := &fakePullRequest{
+			Number: len(f.pulls) + 1,
+			Head:   body["head"].(string),
+			Base:   body["base"].(string),
+			Title:  body["title"].(string),
+			Body:   body["body"].(string),
+			Open:   true,
+		}
+		f.pulls = append(f.pulls, pr)
+		w.WriteHeader(http.StatusCreated)
+		writeFakeJSON(w, f.pullJSON(pr))
+
+	case r.Method == http.MethodPatch && strings.HasPrefix(path, "pulls/"):
+		pr := f.pull(strings.TrimPrefix(path, "pulls/"))
+		pr.Title, pr.Body = body["title"].(string), body["body"].(string)
+		writeFakeJSON(w, f.pullJSON(pr))
+
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/labels"):
+		if f.failLabels {
+			http.Error(w, `{"message":"Resource not accessible by integration"}`, http.StatusForbidden)
+			return
+		}
+		pr := f.pull(strings.TrimSuffix(strings.TrimPrefix(path, "issues/"), "/labels"))
+		pr.Labels = append(pr.Labels, strs("labels")...)
+		writeFakeJSON(w, []interface{}{})
+
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/requested_reviewers"):
+		pr := f.pull(strings.TrimSuffix(strings.TrimPrefix(path, "pulls/"), "/requested_reviewers"))
+		pr.Reviewers = append(pr.Reviewers, strs("reviewers")...)
+		for _, team := range strs("team_reviewers") {
+			pr.Reviewers = append(pr.Reviewers, "team:"+team)
+		}
+		writeFakeJSON(w, f.pullJSON(pr))
+
+	case r.Method == http.MethodPost && r.URL.Path == "/graphql":
+		vars := body["variables"].(map[string]interface{})
+		pr := f.pull(strings.TrimPrefix(vars["id"].(string), "PR_"))
+		pr.AutoMerge = vars["method"].(string)
+		writeFakeJSON(w, map[string]interface{}{"data": map[string]interface{}{}})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakePullRequests) pull(number string) *fakePullRequest {
+	n, _ := strconv.Atoi(number)
+	return f.pulls[n-1]
+}
+
+func (f *fakePullRequests) pullJSON(pr *fakePullRequest) map[string]interface{} {
+	return map[string]interface{}{
+		"number":   pr.Number,
+		"node_id":  fmt.Sprintf("PR_%d", pr.Number),
+		"html_url": fmt.Sprintf("https://github.com/owner/repo/pull/%d", pr.Number),
+	}
+}
+
+func (f *fakePullRequests) hasRef(branch string) bool {
+	f.fakeGitHub.mu.Lock()
+	defer f.fakeGitHub.mu.Unlock()
+	_, ok := f.refs[branch]
+	return ok
+}
+
+func newPullRequestBatch(url, content string) *commitBatch {
+	dest := newTestDestination(url)
+	dest.Mode = DestinationModePullRequest
+	dest.PullRequest = PullRequestConfig{
+		Labels:        []string{"data"},
+		Reviewers:     []string{"octocat"},
+		TeamReviewers: []string{"analytics"},
+		AutoMerge:     true,
+		MergeMethod:   "squash",
+	}
+	return &commitBatch{
+		Destination: *dest,
+		Changes:     []FileChange{{Path: "data/power.json", Content: []byte(content)}},
+		Queries:     map[string][]string{"data/power.json": {"power usage"}},
+	}
+}
+
+func TestCommitViaPullRequestOpensAndUpdatesPullRequest(t *testing.T) {
+	wadge.RunTest(t, func() {
+		fake := &fakePullRequests{fakeGitHub: newFakeGitHub(map[string]string{"data/power.json": "1\n"})}
+		server := httptest.NewServer(fake)
+		defer server.Close()
+
+		result, err := commitViaPullRequest(&SettingsConfig{}, newPullRequestBatch(server.URL, "2\n"))
+		if err != nil {
+			t.Fatalf("commitViaPullRequest failed: %s", err)
+		}
+		if result.Branch != "q2git/power-usage" || result.PullRequest == nil || !result.PullRequest.Created || len(result.Warnings) != 0 {
+			t.Fatalf("unexpected result: %+v", result)
+		}
+		if got, _ := fake.file("q2git/power-usage", "data/power.json"); got != "2\n" {
+			t.Fatalf("expected the change on the head branch, got %q", got)
+		}
+		if got, _ := fake.file("main", "data/power.json"); got != "1\n" {
+			t.Fatalf("expected the base branch to be left alone, got %q", got)
+		}
+		pr := fake.pulls[0]
+		if pr.Head != "q2git/power-usage" || pr.Base != "main" || pr.Title != "q2git: update power usage" {
+			t.Fatalf("unexpected pull request: %+v", pr)
+		}
+		if strings.Join(pr.Labels, ",") != "data" || strings.Join(pr.Reviewers, ",") != "octocat,team:analytics" || pr.AutoMerge != "SQUASH" {
+			t.Fatalf("expected labels, reviewers and auto-merge, got %+v", pr)
+		}
+
+		// The open pull request is updated with a commit on top of its branch.
+		result, err = commitViaPullRequest(&SettingsConfig{}, newPullRequestBatch(server.URL, "3\n"))
+		if err != nil {
+			t.Fatalf("commitViaPullRequest failed: %s", err)
+		}
+		if len(fake.pulls) != 1 || result.PullRequest.Created || result.PullRequest.Number != 1 {
+			t.Fatalf("expected the open pull request to be updated, got %+v", result.PullRequest)
+		}
+		if got, _ := fake.file("q2git/power-usage", "data/power.json"); got != "3\n" || len(fake.pulls[0].Labels) != 1 {
+			t.Fatalf("expected one more commit and no relabelling, got %q and %v", got, fake.pulls[0].Labels)
+		}
+		if !strings.Contains(pr.Body, "`data/power.json`") {
+			t.Fatalf("expected the body to list the changed file, got %q", pr.Body)
+		}
+	})
+}
+
+func TestCommitViaPullRequestResetsStaleBranch(t *testing.T) {
+	wadge.RunTest(t, func() {
+		fake := &fakePullRequests{fakeGitHub: newFakeGitHub(map[string]string{"data/power.json": "1\n"})}
+		fake.refs["q2git/power-usage"] = fake.commitFiles(fake.refs["main"], map[string]string{"stale.txt": "merged long ago"})
+		server := httptest.NewServer(fake)
+		defer server.Close()
+
+		if _, err := commitViaPullRequest(&SettingsConfig{}, newPullRequestBatch(server.URL, "2\n")); err != nil {
+			t.Fatalf("commitViaPullRequest failed: %s", err)
+		}
+		if _, ok := fake.file("q2git/power-usage", "stale.txt"); ok {
+			t.Fatalf("expected the stale branch to be reset to main")
+		}
+		if parents := fake.commits[fake.refs["q2git/power-usage"]].Parents; len(parents) != 1 || parents[0] != fake.refs["main"] {
+			t.Fatalf("expected the commit to start from main, got parents %v", parents)
+		}
+	})
+}
+
+func TestCommitViaPullRequestKeepsConfiguredBranchWithOwnCommits(t *testing.T) {
+	wadge.RunTest(t, func() {
+		fake := &fakePullRequests{fakeGitHub: newFakeGitHub(map[string]string{"data/power.json": "1\n"})}
+		shared := fake.commitFiles(fake.refs["main"], map[string]string{"notes.md": "work in progress"})
+		fake.refs["shared"] = shared
+		server := httptest.NewServer(fake)
+		defer server.Close()
+
+		batch := newPullRequestBatch(server.URL, "2\n")
+		batch.Destination.PullRequest.Branch = "shared"
+		_, err := commitViaPullRequest(&SettingsConfig{}, batch)
+		if err == nil || !errors.Is(err, errConflict) || !strings.Contains(err.Error(), "pull_request.branch 'shared' has 1 commits not on main") {
+			t.Fatalf("expected the shared branch to be refused, got %v", err)
+		}
+		if fake.refs["shared"] != shared {
+			t.Fatalf("expected the shared branch to be left alone")
+		}
+
+		// Once its commits are on the base branch, it is reset like a
+		// generated one.
+		fake.refs["main"] = shared
+		if _, err := commitViaPullRequest(&SettingsConfig{}, batch); err != nil {
+			t.Fatalf("commitViaPullRequest failed: %s", err)
+		}
+		if parents := fake.commits[fake.refs["shared"]].Parents; len(parents) != 1 || parents[0] != shared {
+			t.Fatalf("expected the commit to start from main, got parents %v", parents)
+		}
+	})
+}
+
+func TestCommitViaPullRequestDeletesUnusedBranch(t *testing.T) {
+	wadge.RunTest(t, func() {
+		fake := &fakePullRequests{fakeGitHub: newFakeGitHub(map[string]string{"data/power.json": "1\n"})}
+		server := httptest.NewServer(fake)
+		defer server.Close()
+
+		result, err := commitViaPullRequest(&SettingsConfig{}, newPullRequestBatch(server.URL, "1\n"))
+		if err != nil {
+			t.Fatalf("commitViaPullRequest failed: %s", err)
+		}
+		if !result.Unchanged || result.PullRequest != nil || result.Branch != "main" || len(fake.pulls) != 0 {
+			t.Fatalf("expected nothing to be proposed, got %+v", result)
+		}
+		if fake.hasRef("q2git/power-usage") {
+			t.Fatalf("expected the prepared branch to be deleted")
+		}
+
+		batch := newPullRequestBatch(server.URL, "1\n")
+		batch.Destination.PullRequest.Branch = "shared"
+		if _, err := commitViaPullRequest(&SettingsConfig{}, batch); err != nil {
+			t.Fatalf("commitViaPullRequest failed: %s", err)
+		}
+		if !fake.hasRef("shared") {
+			t.Fatalf("expected a configured branch to be kept")
+		}
+	})
+}
+
+func TestCommitViaPullRequestWarnsWhenDecoratingFails(t *testing.T) {
+	wadge.RunTest(t, func() {
+		fake := &fakePullRequests{fakeGitHub: newFakeGitHub(map[string]string{"data/power.json": "1\n"}), failLabels: true}
+		server := httptest.NewServer(fake)
+		defer server.Close()
+
+		result, err := commitViaPullRequest(&SettingsConfig{}, newPullRequestBatch(server.URL, "2\n"))
+		if err != nil {
+			t.Fatalf("expected the commit to succeed, got %s", err)
+		}
+		if result.PullRequest == nil || len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "failed to add labels") {
+			t.Fatalf("expected a warning about the labels, got %+v", result)
+		}
+		if fake.pulls[0].AutoMerge != "SQUASH" {
+			t.Fatalf("expected the remaining steps to run after the failure")
+		}
+	})
+}
+
+func TestCommitViaPullRequestValidatesDestination(t *testing.T) {
+	batch := newPullRequestBatch("https://api.github.com", "2\n")
+	batch.Destination.Signing = SigningSSH
+	batch.Destination.SigningKey = testSSHSigningKey
+	if _, err := commitViaPullRequest(&SettingsConfig{}, batch); err == nil || !strings.Contains(err.Error(), "needs destination.author") {
+		t.Fatalf("expected signing without an author to be rejected, got %v", err)
+	}
+}
+
+func TestSanitizeBranchName(t *testing.T) {
+	for name, want := range map[string]string{
+		"q2git/power":            "q2git/power",
+		"q2git/power usage":      "q2git/power-usage",
+		"q2git/../etc":           "q2git/etc",
+		"q2git/a..b":             "q2git/a.b",
+		"q2git/.hidden/x.lock":   "q2git/hidden/x",
+		"q2git//trailing.":       "q2git/trailing",
+		"q2git/ünïcode~^:?*[\\@": "q2git/-n-code--------",
+		"../..":                  "q2git",
+	} {
+		if got := sanitizeBranchName(name); got != want {
+			t.Errorf("sanitizeBranchName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -40,3 +40,34 @@ func ExecuteQuery(query string, data []byte) ([]byte, error) {
 
 	return json.MarshalIndent(output, "", "  ")
 }
+
+// jqExpression is a jq expression compiled once and evaluated against many
+// values, such as the records of an upsert.
+type jqExpression struct {
+	src  string
+	code *gojq.Code
+}
+
+func compileExpression(src string) (*jqExpression, error) {
+	parsed, err := gojq.Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse jq expression '%s': %w", src, err)
+	}
+	code, err := gojq.Compile(parsed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile jq expression '%s': %w", src, err)
+	}
+	return &jqExpression{src: src, code: code}, nil
+}
+
+// Eval returns the first output of the expression for v.
+func (e *jqExpression) Eval(v interface{}) (interface{}, error) {
+	out, ok := e.code.Run(v).Next()
+	if !ok {
+		return nil, fmt.Errorf("jq expression '%s' produced no output", e.src)
+	}
+	if err, ok := out.(error); ok {
+		return nil, fmt.Errorf("jq expression '%s' failed: %w", e.src, err)
+	}
+	return out, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxGitHubAttempts = 5
+	// maxGitHubRetryWait caps the time one API call spends waiting between
+	// all of its attempts. Calls run inside an inbound HTTP request, so longer
+	// waits fail instead of holding it open past gateway timeouts.
+	maxGitHubRetryWait = 30 * time.Second
+)
+
+// idempotentMethods can be repeated without changing the outcome.
+var idempotentMethods = map[string]bool{
+	http.MethodGet:    true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// githubRetryDelay decides whether a failed GitHub API call is retried and
+// after how long: rate limits wait for Retry-After or X-RateLimit-Reset, or
+// back off with jitter when they give neither, and server errors of idempotent methods back off with jitter. A POST that failed
+// with a server error may still have created its object, so it is not
+// repeated. Other errors are not retried.
+func githubRetryDelay(method string, err error, attempt int, now time.Time) (time.Duration, bool) {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		return 0, false
+	}
+
+	switch status := apiErr.StatusCode; {
+	case status == http.StatusForbidden || status == http.StatusTooManyRequests:
+		if seconds, err := strconv.Atoi(apiErr.Header.Get("Retry-After")); err == nil {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if apiErr.Header.Get("X-RateLimit-Remaining") == "0" {
+			reset, err := strconv.ParseInt(apiErr.Header.Get("X-RateLimit-Reset"), 10, 64)
+			if err != nil {
+				return 0, false
+			}
+			// One extra second, as the reset time is truncated.
+			delay := time.Unix(reset, 0).Sub(now) + time.Second
+			if delay < 0 {
+				delay = 0
+			}
+			return delay, true
+		}
+		// GitHub advises waiting a minute on secondary rate limits without
+		// Retry-After, which exceeds maxGitHubRetryWait; backing off still
+		// gives the limit a chance to clear within it.
+		if status == http.StatusTooManyRequests || strings.Contains(strings.ToLower(apiErr.Body), "secondary rate limit") {
+			return commitBackoff(attempt), true
+		}
+		return 0, false
+	case status >= 500:
+		if !idempotentMethods[method] {
+			return 0, false
+		}
+		return commitBackoff(attempt), true
+	default:
+		return 0, false
+	}
+}
+
+// rateLimitQuota is one resource of GET /rate_limit.
+type rateLimitQuota struct {
+	Limit     int    `json:"limit"`
+	Remaining int    `json:"remaining"`
+	Used      int    `json:"used"`
+	Reset     string `json:"reset"`
+}
+
+// githubRateLimits fetches the remaining quota of the destination's token.
+// Querying it does not count against the limit. It is a single attempt, as
+// /api/status should report a failure rather than wait it out.
+func githubRateLimits(cfg *DestinationConfig) (map[string]rateLimitQuota, error) {
+	var data struct {
+		Resources map[string]struct {
+			Limit     int   `json:"limit"`
+			Remaining int   `json:"remaining"`
+			Used      int   `json:"used"`
+			Reset     int64 `json:"reset"`
+		} `json:"resources"`
+	}
+	token, err := githubToken(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := jsonAPIRequest("GET", cfg.APIURL+"/rate_limit", githubHeaders(token), nil, &data); err != nil {
+		return nil, fmt.Errorf("failed to get rate limit: %w", err)
+	}
+
+	quotas := make(map[string]rateLimitQuota, len(data.Resources))
+	for name, r := range data.Resources {
+		quotas[name] = rateLimitQuota{
+			Limit:     r.Limit,
+			Remaining: r.Remaining,
+			Used:      r.Used,
+			Reset:     time.Unix(r.Reset, 0).UTC().Format(time.RFC3339),
+		}
+	}
+	return quotas, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.wasmcloud.dev/wadge"
+)
+
+func TestGitHubRetryDelay(t *testing.T) {
+	now := time.Unix(1_800_000_000, 0)
+	header := func(kv ...string) http.Header {
+		h := http.Header{}
+		for i := 0; i < len(kv); i += 2 {
+			h.Set(kv[i], kv[i+1])
+		}
+		return h
+	}
+
+	tests := []struct {
+		name  string
+		err   error
+		delay time.Duration
+		retry bool
+	}{
+		{
+			name:  "retry after",
+			err:   &apiError{StatusCode: http.StatusForbidden, Header: header("Retry-After", "30")},
+			delay: 30 * time.Second,
+			retry: true,
+		},
+		{
+			name:  "primary limit exhausted",
+			err:   &apiError{StatusCode: http.StatusForbidden, Header: header("X-RateLimit-Remaining", "0", "X-RateLimit-Reset", "1800000010")},
+			delay: 11 * time.Second,
+			retry: true,
+		},
+		{
+			name: "forbidden",
+			err:  &apiError{StatusCode: http.StatusForbidden, Header: header("X-RateLimit-Remaining", "4999"), Body: `{"message":"Resource not accessible by integration"}`},
+		},
+		{
+			name: "not found",
+			err:  &apiError{StatusCode: http.StatusNotFound, Header: header()},
+		},
+		{
+			name: "network error",
+			err:  errors.New("connection refused"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, retry := githubRetryDelay(http.MethodGet, tt.err, 0, now)
+			if delay != tt.delay || retry != tt.retry {
+				t.Fatalf("want (%s, %t), got (%s, %t)", tt.delay, tt.retry, delay, retry)
+			}
+		})
+	}
+
+	secondary := &apiError{StatusCode: http.StatusForbidden, Header: header(), Body: `{"message":"You have exceeded a secondary rate limit."}`}
+	if delay, retry := githubRetryDelay(http.MethodPost, secondary, 2, now); !retry || delay <= 0 || delay > commitBackoffMax {
+		t.Fatalf("expected a jittered backoff for secondary rate limits without Retry-After, got (%s, %t)", delay, retry)
+	}
+	if delay, retry := githubRetryDelay(http.MethodGet, &apiError{StatusCode: http.StatusBadGateway}, 2, now); !retry || delay <= 0 || delay > commitBackoffMax {
+		t.Fatalf("expected a jittered backoff for server errors, got (%s, %t)", delay, retry)
+	}
+	if _, retry := githubRetryDelay(http.MethodPost, &apiError{StatusCode: http.StatusBadGateway}, 0, now); retry {
+		t.Fatalf("expected a POST failing with a server error not to be retried")
+	}
+	if delay, retry := githubRetryDelay(http.MethodPost, &apiError{StatusCode: http.StatusForbidden, Header: header("Retry-After", "30")}, 0, now); !retry || delay != 30*time.Second {
+		t.Fatalf("expected a rate limited POST to be retried, got (%s, %t)", delay, retry)
+	}
+}
+
+func TestGitHubAPIRequestRetriesRateLimitedCalls(t *testing.T) {
+	wadge.RunTest(t, func() {
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if calls == 1 {
+				w.Header().Set("Retry-After", "0")
+				http.Error(w, `{"message":"API rate limit exceeded"}`, http.StatusTooManyRequests)
+				return
+			}
+			writeFakeJSON(w, map[string]string{"sha": "abc"})
+		}))
+		defer server.Close()
+
+		var data struct {
+			SHA string `json:"sha"`
+		}
+		if err := githubAPIRequest("GET", server.URL+"/repos/owner/repo", newTestDestination(server.URL), nil, &data); err != nil {
+			t.Fatalf("githubAPIRequest failed: %s", err)
+		}
+		if calls != 2 || data.SHA != "abc" {
+			t.Fatalf("expected one retry, got %d calls and %+v", calls, data)
+		}
+	})
+}
+
+func TestGitHubAPIRequestRetriesServerErrorsOfIdempotentMethods(t *testing.T) {
+	wadge.RunTest(t, func() {
+		calls := map[string]int{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls[r.Method]++; calls[r.Method] == 1 {
+				http.Error(w, `{"message":"Server Error"}`, http.StatusBadGateway)
+				return
+			}
+			writeFakeJSON(w, map[string]string{"sha": "abc"})
+		}))
+		defer server.Close()
+
+		dest := newTestDestination(server.URL)
+		if err := githubAPIRequest("PATCH", server.URL+"/repos/owner/repo/git/refs/heads/main", dest, map[string]string{}, nil); err != nil {
+			t.Fatalf("expected the PATCH to be retried, got %s", err)
+		}
+		if err := githubAPIRequest("POST", server.URL+"/repos/owner/repo/git/commits", dest, map[string]string{}, nil); err == nil {
+			t.Fatalf("expected the POST to fail without a retry")
+		}
+		if calls["PATCH"] != 2 || calls["POST"] != 1 {
+			t.Fatalf("unexpected calls: %v", calls)
+		}
+	})
+}
+
+func TestGitHubAPIRequestRetriesSecondaryRateLimits(t *testing.T) {
+	wadge.RunTest(t, func() {
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls++; calls < 3 {
+				http.Error(w, `{"message":"You have exceeded a secondary rate limit."}`, http.StatusForbidden)
+				return
+			}
+			writeFakeJSON(w, map[string]string{"sha": "abc"})
+		}))
+		defer server.Close()
+
+		err := githubAPIRequest("POST", server.URL+"/repos/owner/repo/git/blobs", newTestDestination(server.URL), map[string]string{}, nil)
+		if err != nil || calls != 3 {
+			t.Fatalf("expected the call to succeed after 2 retries, got %v after %d calls", err, calls)
+		}
+	})
+}
+
+func TestGitHubAPIRequestCapsTheTotalWait(t *testing.T) {
+	wadge.RunTest(t, func() {
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Each wait fits maxGitHubRetryWait, but not both together.
+			if calls++; calls == 1 {
+				w.Header().Set("Retry-After", "1")
+			} else {
+				w.Header().Set("Retry-After", "30")
+			}
+			http.Error(w, `{"message":"API rate limit exceeded"}`, http.StatusTooManyRequests)
+		}))
+		defer server.Close()
+
+		err := githubAPIRequest("GET", server.URL+"/repos/owner/repo", newTestDestination(server.URL), nil, nil)
+		if !errors.Is(err, errRateLimited) || calls != 2 {
+			t.Fatalf("expected a rate limit error after 2 calls, got %v after %d", err, calls)
+		}
+	})
+}
+
+func TestGitHubRateLimitsDoesNotRetry(t *testing.T) {
+	wadge.RunTest(t, func() {
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, `{"message":"API rate limit exceeded"}`, http.StatusTooManyRequests)
+		}))
+		defer server.Close()
+
+		if _, err := githubRateLimits(newTestDestination(server.URL)); err == nil || calls != 1 {
+			t.Fatalf("expected one failed call, got %v after %d", err, calls)
+		}
+	})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Record formats a file can be split into, for upserts and retention.
+const (
+	// recordsJSON are the elements of a JSON array.
+	recordsJSON = "json"
+	// recordsNDJSON are the lines of a newline-delimited JSON file.
+	recordsNDJSON = "ndjson"
+	// recordsCSV are the rows of a CSV file below its header.
+	recordsCSV = "csv"
+	// recordsLines are the non-empty lines of a text file.
+	recordsLines = "lines"
+)
+
+// record is one record of a file: its encoded form and the value jq
+// expressions see, which is the decoded JSON value, an object of column name
+// to string for CSV rows, or the line as a string.
+type record struct {
+	raw     json.RawMessage
+	row     []string
+	line    string
+	value   interface{}
+	sortKey interface{}
+}
+
+// recordFile is a file split into records. header holds the CSV header, or
+// the leading lines kept verbatim in a text file.
+type recordFile struct {
+	format  string
+	header  []string
+	records []record
+	// trailingNewline is whether a text file ended with a newline.
+	trailingNewline bool
+}
+
+// jsonRecordFormat picks the record format of a JSON file by extension: NDJSON
+// lines for .ndjson and .jsonl, array elements otherwise.
+func jsonRecordFormat(outputPath string) string {
+	switch strings.ToLower(path.Ext(outputPath)) {
+	case ".ndjson", ".jsonl":
+		return recordsNDJSON
+	default:
+		return recordsJSON
+	}
+}
+
+// parseRecords splits content into records. headerLines only applies to text
+// files.
+func parseRecords(format string, content []byte, headerLines int) (*recordFile, error) {
+	file := &recordFile{format: format}
+	if len(bytes.TrimSpace(content)) == 0 {
+		return file, nil
+	}
+
+	switch format {
+	case recordsJSON, recordsNDJSON:
+		var raws []json.RawMessage
+		var err error
+		if format == recordsJSON {
+			err = json.Unmarshal(content, &raws)
+		} else {
+			raws, err = jsonValues(content, false)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, raw := range raws {
+			if err := file.addJSON(raw); err != nil {
+				return nil, err
+			}
+		}
+	case recordsCSV:
+		rows, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		file.header = rows[0]
+		for _, row := range rows[1:] {
+			file.addRow(row)
+		}
+	case recordsLines:
+		// Blank lines stay with the record below them, or trail the last one.
+		file.trailingNewline = bytes.HasSuffix(content, []byte("\n"))
+		var blank []string
+		for i, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+			switch {
+			case i < headerLines:
+				file.header = append(file.header, line)
+			case line == "":
+				blank = append(blank, line)
+			default:
+				raw := strings.Join(append(blank, line), "\n")
+				file.records = append(file.records, record{line: raw, value: line})
+				blank = nil
+			}
+		}
+		if n := len(file.records); n > 0 && len(blank) > 0 {
+			file.records[n-1].line += strings.Repeat("\n", len(blank))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported record format '%s'", format)
+	}
+	return file, nil
+}
+
+func (f *recordFile) addJSON(raw json.RawMessage) error {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return err
+	}
+	f.records = append(f.records, record{raw: raw, value: value})
+	return nil
+}
+
+func (f *recordFile) addRow(row []string) {
+	value := make(map[string]interface{}, len(f.header))
+	for i, column := range f.header {
+		value[column] = row[i]
+	}
+	f.records = append(f.records, record{row: row, value: value})
+}
+
+// encode writes the records back in the format they were read in.
+func (f *recordFile) encode() ([]byte, error) {
+	switch f.format {
+	case recordsJSON, recordsNDJSON:
+		raws := make([]json.RawMessage, len(f.records))
+		for i, r := range f.records {
+			raws[i] = r.raw
+		}
+		if f.format == recordsJSON {
+			return encodeJSONArray(raws)
+		}
+		return encodeNDJSON(nil, raws)
+	case recordsCSV:
+		if f.header == nil {
+			return nil, nil
+		}
+		var out bytes.Buffer
+		w := csv.NewWriter(&out)
+		_ = w.Write(f.header)
+		for _, r := range f.records {
+			_ = w.Write(r.row)
+		}
+		w.Flush()
+		return out.Bytes(), w.Error()
+	default:
+		lines := append([]string(nil), f.header...)
+		for _, r := range f.records {
+			lines = append(lines, r.line)
+		}
+		out := strings.Join(lines, "\n")
+		if f.trailingNewline && out != "" {
+			out += "\n"
+		}
+		return []byte(out), nil
+	}
+}
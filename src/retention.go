@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rollover periods accepted in RetentionConfig.Rollover.
+const (
+	RolloverDaily   = "daily"
+	RolloverMonthly = "monthly"
+	RolloverYearly  = "yearly"
+)
+
+// rolloverPath partitions outputPath by the period containing now, e.g.
+// "data.csv" rolls over daily to "data/2026/10/16.csv".
+func rolloverPath(outputPath, rollover string, now time.Time) (string, error) {
+	var layout string
+	switch rollover {
+	case "":
+		return outputPath, nil
+	case RolloverDaily:
+		layout = "2006/01/02"
+	case RolloverMonthly:
+		layout = "2006/01"
+	case RolloverYearly:
+		layout = "2006"
+	default:
+		return "", fmt.Errorf("unsupported retention rollover '%s'", rollover)
+	}
+
+	ext := path.Ext(outputPath)
+	return strings.TrimSuffix(outputPath, ext) + "/" + now.UTC().Format(layout) + ext, nil
+}
+
+// retentionFormat returns the records retention counts for a write mode.
+func retentionFormat(mode, outputPath string) (string, error) {
+	switch mode {
+	case WriteModeCSVAppend:
+		return recordsCSV, nil
+	case WriteModeJSONArrayAppend:
+		return recordsJSON, nil
+	case WriteModeNDJSONAppend:
+		return recordsNDJSON, nil
+	case WriteModeUpsert:
+		return upsertFormat(outputPath), nil
+	case WriteModeJSONMerge:
+		return "", fmt.Errorf("retention does not apply to json_merge")
+	default:
+		return recordsLines, nil
+	}
+}
+
+// retentionHeaderLines returns the lines kept at the top of a text file. A
+// .csv file written line by line keeps its header row unless header_lines
+// says otherwise.
+func retentionHeaderLines(r RetentionConfig, format, outputPath string) int {
+	if r.HeaderLines == 0 && format == recordsLines && strings.EqualFold(path.Ext(outputPath), ".csv") {
+		return 1
+	}
+	return r.HeaderLines
+}
+
+func validateRetention(r RetentionConfig, mode, outputPath string) error {
+	if r.MaxRecords < 0 || r.MaxAgeDays < 0 || r.HeaderLines < 0 {
+		return fmt.Errorf("retention limits must not be negative")
+	}
+	if r.MaxAgeDays > 0 && r.Timestamp == "" {
+		return fmt.Errorf("retention max_age_days needs a timestamp expression")
+	}
+	if r.Timestamp != "" {
+		if _, err := compileExpression(r.Timestamp); err != nil {
+			return err
+		}
+	}
+	if r.MaxRecords > 0 || r.MaxAgeDays > 0 {
+		if _, err := retentionFormat(mode, outputPath); err != nil {
+			return err
+		}
+	}
+	_, err := rolloverPath(outputPath, r.Rollover, time.Now())
+	return err
+}
+
+// applyRetention drops the records of content that fall outside the policy
+// and returns how many were dropped. Content is returned as is if none were.
+func applyRetention(change FileChange, content []byte, now time.Time) ([]byte, int, error) {
+	r := change.Retention
+	if r.MaxRecords == 0 && r.MaxAgeDays == 0 {
+		return content, 0, nil
+	}
+
+	format, err := retentionFormat(change.WriteMode, change.Path)
+	if err != nil {
+		return nil, 0, err
+	}
+	file, err := parseRecords(format, content, retentionHeaderLines(r, format, change.Path))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read records: %w", err)
+	}
+
+	kept := file.records
+	if r.MaxAgeDays > 0 {
+		timestamp, err := compileExpression(r.Timestamp)
+		if err != nil {
+			return nil, 0, err
+		}
+		cutoff := now.AddDate(0, 0, -r.MaxAgeDays)
+
+		kept = make([]record, 0, len(file.records))
+		for _, rec := range file.records {
+			v, err := timestamp.Eval(rec.value)
+			if err != nil {
+				return nil, 0, err
+			}
+			t, err := recordTime(v)
+			if err != nil {
+				return nil, 0, err
+			}
+			if !t.Before(cutoff) {
+				kept = append(kept, rec)
+			}
+		}
+	}
+	if r.MaxRecords > 0 && len(kept) > r.MaxRecords {
+		kept = kept[len(kept)-r.MaxRecords:]
+	}
+
+	dropped := len(file.records) - len(kept)
+	if dropped == 0 {
+		return content, 0, nil
+	}
+	file.records = kept
+	out, err := file.encode()
+	return out, dropped, err
+}
+
+var recordTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// recordTime reads a timestamp given as Unix seconds or as an RFC 3339 style
+// date, assumed to be UTC without a zone.
+func recordTime(v interface{}) (time.Time, error) {
+	switch v := v.(type) {
+	case int:
+		return time.Unix(int64(v), 0), nil
+	case float64:
+		return time.Unix(int64(v), 0), nil
+	case string:
+		s := strings.TrimSpace(v)
+		if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+			return time.Unix(int64(seconds), 0), nil
+		}
+		for _, layout := range recordTimeLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("unsupported timestamp %v", v)
+}
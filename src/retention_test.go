@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApplyRetention(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name    string
+		change  FileChange
+		content string
+		want    string
+		dropped int
+	}{
+		{
+			name:    "last lines keep the header",
+			change:  FileChange{Path: "data.csv", WriteMode: WriteModeAppend, Retention: RetentionConfig{MaxRecords: 2, HeaderLines: 1}},
+			content: "date,kwh\n\n2026-10-13, 1\n2026-10-14, 2\n2026-10-15, 3",
+			want:    "date,kwh\n2026-10-14, 2\n2026-10-15, 3",
+			dropped: 1,
+		},
+		{
+			name:    "appending past max_records keeps the csv header",
+			change:  FileChange{Path: "data.csv", WriteMode: WriteModeAppend, Retention: RetentionConfig{MaxRecords: 2}},
+			content: "date,kwh\n2026-10-13,1\n2026-10-14,2\n2026-10-15,3\n",
+			want:    "date,kwh\n2026-10-14,2\n2026-10-15,3\n",
+			dropped: 1,
+		},
+		{
+			name:    "blank lines stay with their records",
+			change:  FileChange{Path: "data.log", WriteMode: WriteModeAppend, Retention: RetentionConfig{MaxRecords: 2}},
+			content: "a\n\nb\nc\n\n",
+			want:    "\nb\nc\n\n",
+			dropped: 1,
+		},
+		{
+			name:    "last csv rows",
+			change:  FileChange{Path: "data.csv", WriteMode: WriteModeCSVAppend, Retention: RetentionConfig{MaxRecords: 1}},
+			content: "date,kwh\n2026-10-14,2\n2026-10-15,3\n",
+			want:    "date,kwh\n2026-10-15,3\n",
+			dropped: 1,
+		},
+		{
+			name:    "last days by timestamp",
+			change:  FileChange{Path: "data.json", WriteMode: WriteModeJSONArrayAppend, Retention: RetentionConfig{MaxAgeDays: 2, Timestamp: ".ts"}},
+			content: "[\n  {\"ts\":\"2026-10-13T00:00:00Z\"},\n  {\"ts\":\"2026-10-15\"},\n  {\"ts\":1792152000}\n]\n",
+			want:    "[\n  {\"ts\":\"2026-10-15\"},\n  {\"ts\":1792152000}\n]\n",
+			dropped: 1,
+		},
+		{
+			name:    "nothing to drop",
+			change:  FileChange{Path: "data.ndjson", WriteMode: WriteModeNDJSONAppend, Retention: RetentionConfig{MaxRecords: 5}},
+			content: "{\"a\": 1}\n",
+			want:    "{\"a\": 1}\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, dropped, err := applyRetention(tc.change, []byte(tc.content), now)
+			if err != nil {
+				t.Fatalf("applyRetention failed: %s", err)
+			}
+			if string(got) != tc.want || dropped != tc.dropped {
+				t.Fatalf("unexpected result:\nwant %q (%d dropped)\ngot  %q (%d dropped)", tc.want, tc.dropped, got, dropped)
+			}
+		})
+	}
+}
+
+func TestRolloverPath(t *testing.T) {
+	now := time.Date(2026, 10, 16, 23, 0, 0, 0, time.UTC)
+	for rollover, want := range map[string]string{
+		"":              "data.csv",
+		RolloverDaily:   "data/2026/10/16.csv",
+		RolloverMonthly: "data/2026/10.csv",
+		RolloverYearly:  "data/2026.csv",
+	} {
+		got, err := rolloverPath("data.csv", rollover, now)
+		if err != nil || got != want {
+			t.Fatalf("%q: want %s, got %s (%v)", rollover, want, got, err)
+		}
+	}
+}
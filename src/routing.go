@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// commitBatch groups every file bound for the same repo/branch so they can be
+// written in a single commit.
+type commitBatch struct {
+	Destination DestinationConfig
+	Changes     []FileChange
+	// Queries maps each changed path to the queries writing to it.
+	Queries map[string][]string
+}
+
+// destinationFor applies the per-query routing overrides on top of the
+// global destination.
+func destinationFor(base DestinationConfig, q QueryConfig) DestinationConfig {
+	dest := base
+	if q.OutputPath != "" {
+		dest.OutputPath = q.OutputPath
+	}
+	if q.Owner != "" {
+		dest.Owner = q.Owner
+	}
+	if q.Repo != "" {
+		dest.Repo = q.Repo
+	}
+	if q.Branch != "" {
+		dest.Branch = q.Branch
+	}
+	return dest
+}
+
+// groupByDestination buckets results by repo/branch. Results sharing an output
+// path are combined in query order and must agree on how they are written.
+// Output paths and commit messages are rendered as templates for the run.
+func groupByDestination(config *Config, results []queryResult, runID string) ([]commitBatch, error) {
+	var batches []commitBatch
+	var batchData []*templateData
+	batchIndex := map[string]int{}
+	fileIndex := map[string]int{}
+
+	now := time.Now()
+	for _, res := range results {
+		dest := destinationFor(config.Destination, res.query)
+		if dest.OutputPath == "" {
+			return nil, fmt.Errorf("query '%s': no output_path configured", res.Name)
+		}
+
+		result := decodeResult(res.Result)
+		data := newTemplateData(now, runID)
+		data.Query = res.Name
+		data.Queries = []string{res.Name}
+		data.Hash = contentHash(resultContent(res))
+		data.Result = result
+		data.Results[res.Name] = result
+
+		var err error
+		if dest.OutputPath, err = renderOutputPath(dest.OutputPath, data); err != nil {
+			return nil, fmt.Errorf("query '%s': %w", res.Name, err)
+		}
+
+		retention := config.Settings.Retention
+		if res.query.Retention != nil {
+			retention = *res.query.Retention
+		}
+
+		key := fmt.Sprintf("%s/%s@%s", dest.Owner, dest.Repo, dest.Branch)
+		bi, ok := batchIndex[key]
+		if !ok {
+			bi = len(batches)
+			batchIndex[key] = bi
+			batches = append(batches, commitBatch{Destination: dest, Queries: map[string][]string{}})
+			batchData = append(batchData, newTemplateData(now, runID))
+		}
+		batchData[bi].Queries = append(batchData[bi].Queries, res.Name)
+		batchData[bi].Results[res.Name] = result
+
+		writeMode := config.Settings.WriteMode
+		if res.query.WriteMode != "" {
+			writeMode = res.query.WriteMode
+		}
+		writeMode, err = resolveWriteMode(writeMode, dest.OutputPath)
+		if err != nil {
+			return nil, fmt.Errorf("query '%s': %w", res.Name, err)
+		}
+		if err := validateRetention(retention, writeMode, dest.OutputPath); err != nil {
+			return nil, fmt.Errorf("query '%s': %w", res.Name, err)
+		}
+		// Rolled over files are keyed by their partition, so the file for
+		// the current period is the one merged with and trimmed.
+		dest.OutputPath, _ = rolloverPath(dest.OutputPath, retention.Rollover, now)
+
+		if writeMode == WriteModeUpsert {
+			if res.query.Key == "" {
+				return nil, fmt.Errorf("query '%s': upsert needs a key expression", res.Name)
+			}
+			for _, expr := range []string{res.query.Key, res.query.SortBy} {
+				if expr == "" {
+					continue
+				}
+				if _, err := compileExpression(expr); err != nil {
+					return nil, fmt.Errorf("query '%s': %w", res.Name, err)
+				}
+			}
+		}
+
+		lfs := res.query.LFS || dest.LFS.matches(dest.OutputPath)
+		if lfs && dest.Type != "" && dest.Type != DestinationGitHub {
+			return nil, fmt.Errorf("query '%s': lfs is only supported for github destinations", res.Name)
+		}
+
+		batches[bi].Queries[dest.OutputPath] = append(batches[bi].Queries[dest.OutputPath], res.Name)
+
+		chunk := resultContent(res)
+		if isJSONWriteMode(writeMode, dest.OutputPath) {
+			chunk = []byte(res.Result)
+		}
+
+		fileKey := key + ":" + dest.OutputPath
+		if fi, ok := fileIndex[fileKey]; ok {
+			change := &batches[bi].Changes[fi]
+			if change.WriteMode != writeMode || change.Key != res.query.Key || change.SortBy != res.query.SortBy || change.Retention != retention {
+				return nil, fmt.Errorf("query '%s': write_mode, key, sort_by or retention differ from other queries writing to %s",
+					res.Name, dest.OutputPath)
+			}
+			change.LFS = change.LFS || lfs
+			if change.Content, err = combineResults(writeMode, dest.OutputPath, change.Content, chunk); err != nil {
+				return nil, fmt.Errorf("query '%s': %w", res.Name, err)
+			}
+			continue
+		}
+		fileIndex[fileKey] = len(batches[bi].Changes)
+		batches[bi].Changes = append(batches[bi].Changes, FileChange{
+			Path:      dest.OutputPath,
+			Content:   chunk,
+			WriteMode: writeMode,
+			Key:       res.query.Key,
+			SortBy:    res.query.SortBy,
+			Retention: retention,
+			LFS:       lfs,
+		})
+	}
+
+	for i := range batches {
+		dest := batches[i].Destination
+		if dest.Type == DestinationBitbucketServer && len(batches[i].Changes) > 1 {
+			return nil, fmt.Errorf("%s/%s@%s: bitbucket_server commits one file at a time, but queries %s write %d files; route them to different branches or repositories",
+				dest.Owner, dest.Repo, dest.Branch, strings.Join(batches[i].queryNames(nil), ", "), len(batches[i].Changes))
+		}
+
+		data := batchData[i]
+		data.Query = strings.Join(data.Queries, ", ")
+		if len(data.Queries) == 1 {
+			data.Result = data.Results[data.Queries[0]]
+		}
+		var chunks [][]byte
+		for _, change := range batches[i].Changes {
+			chunks = append(chunks, change.Content)
+		}
+		data.Hash = contentHash(chunks...)
+
+		message, err := renderTemplate("commit_message", batches[i].Destination.CommitMessage, data)
+		if err != nil {
+			return nil, err
+		}
+		batches[i].Destination.CommitMessage = message
+	}
+
+	return batches, nil
+}
+
+// writeBatch commits a batch directly to its branch or, in pull_request
+// mode, through a pull request.
+func writeBatch(settings *SettingsConfig, batch *commitBatch) (*CommitResult, error) {
+	switch batch.Destination.Mode {
+	case "", DestinationModeDirect:
+	case DestinationModePullRequest:
+		return commitViaPullRequest(settings, batch)
+	default:
+		return nil, fmt.Errorf("unsupported destination mode '%s'", batch.Destination.Mode)
+	}
+
+	c, err := newCommitter(&batch.Destination)
+	if err != nil {
+		return nil, err
+	}
+	result, err := CommitToGit(c, settings, batch.Changes)
+	if err != nil {
+		return nil, err
+	}
+	result.Branch = batch.Destination.Branch
+	return result, nil
+}
+
+// previewBatch shows what writeBatch would commit. In pull_request mode the
+// changes are compared with the base branch the pull request would target.
+func previewBatch(batch *commitBatch) (*CommitPreview, error) {
+	switch batch.Destination.Mode {
+	case "", DestinationModeDirect, DestinationModePullRequest:
+	default:
+		return nil, fmt.Errorf("unsupported destination mode '%s'", batch.Destination.Mode)
+	}
+
+	c, err := newCommitter(&batch.Destination)
+	if err != nil {
+		return nil, err
+	}
+	return PreviewCommit(c, batch.Changes)
+}
+
+// queryNames lists the queries writing to the given paths, or to any path
+// when paths is nil, in a stable order.
+func (b *commitBatch) queryNames(paths []string) []string {
+	if paths == nil {
+		for path := range b.Queries {
+			paths = append(paths, path)
+		}
+	}
+
+	seen := map[string]bool{}
+	var names []string
+	for _, path := range paths {
+		for _, name := range b.Queries[path] {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resultContent turns a query result into file bytes. String results are
+// written unquoted so queries can emit CSV or plain text lines.
+func resultContent(res queryResult) []byte {
+	chunk := []byte(res.Result)
+	var unquoted string
+	if err := json.Unmarshal(chunk, &unquoted); err == nil {
+		chunk = []byte(unquoted)
+	}
+	return chunk
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.wasmcloud.dev/wadge"
+)
+
+func TestGroupByDestinationRendersTemplates(t *testing.T) {
+	config := &Config{
+		Destination: DestinationConfig{
+			Owner:         "owner",
+			Repo:          "repo",
+			Branch:        "main",
+			OutputPath:    `readings/{{.Date "2006"}}/{{.Query}}.csv`,
+			CommitMessage: `{{.Query}}: {{.Result.total}} kWh ({{.JQ ".parts | length"}} parts, run {{.RunID}})`,
+		},
+	}
+	results := []queryResult{{
+		Name:   "power",
+		Result: json.RawMessage(`{"total": 12345678, "parts": [1, 2]}`),
+	}}
+
+	batches, err := groupByDestination(config, results, "run-1")
+	if err != nil {
+		t.Fatalf("groupByDestination failed: %s", err)
+	}
+
+	wantPath := "readings/" + time.Now().UTC().Format("2006") + "/power.csv"
+	if got := batches[0].Changes[0].Path; got != wantPath {
+		t.Fatalf("unexpected output path: want %s, got %s", wantPath, got)
+	}
+	if got, want := batches[0].Destination.CommitMessage, "power: 12345678 kWh (2 parts, run run-1)"; got != want {
+		t.Fatalf("unexpected commit message: want %q, got %q", want, got)
+	}
+
+	config.Destination.OutputPath = "{{.Result.missing}}.csv"
+	if _, err := groupByDestination(config, results, "run-1"); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("expected an error for a missing result field, got %v", err)
+	}
+
+	config.Destination.OutputPath = "../{{.Query}}.csv"
+	if _, err := groupByDestination(config, results, "run-1"); err == nil {
+		t.Fatalf("expected an output path outside the repository to be rejected")
+	}
+}
+
+func TestGroupByDestinationRoutesQueries(t *testing.T) {
+	config := &Config{
+		Destination: DestinationConfig{Owner: "owner", Repo: "repo", Branch: "main", OutputPath: "data/default.json"},
+	}
+	results := []queryResult{
+		{Name: "power", Result: json.RawMessage(`1`), query: QueryConfig{OutputPath: "data/power.json"}},
+		{Name: "water", Result: json.RawMessage(`2`), query: QueryConfig{OutputPath: "data/water.json"}},
+		{Name: "gas", Result: json.RawMessage(`3`), query: QueryConfig{Repo: "archive", Branch: "history"}},
+		{Name: "heat", Result: json.RawMessage(`4`)},
+	}
+
+	batches, err := groupByDestination(config, results, "run")
+	if err != nil {
+		t.Fatalf("groupByDestination failed: %s", err)
+	}
+	if len(batches) != 2 {
+		t.Fatalf("expected one batch per repo and branch, got %d", len(batches))
+	}
+
+	primary, archive := batches[0], batches[1]
+	if primary.Destination.Repo != "repo" || primary.Destination.Branch != "main" || len(primary.Changes) != 3 {
+		t.Fatalf("unexpected default batch: %+v", primary)
+	}
+	for i, want := range []string{"data/power.json", "data/water.json", "data/default.json"} {
+		if primary.Changes[i].Path != want {
+			t.Fatalf("expected change %d to write %s, got %s", i, want, primary.Changes[i].Path)
+		}
+	}
+	if got := primary.Queries["data/water.json"]; len(got) != 1 || got[0] != "water" {
+		t.Fatalf("expected water to be recorded for its path, got %v", got)
+	}
+	if archive.Destination.Repo != "archive" || archive.Destination.Branch != "history" || archive.Destination.Owner != "owner" {
+		t.Fatalf("unexpected routed destination: %+v", archive.Destination)
+	}
+	if len(archive.Changes) != 1 || archive.Changes[0].Path != "data/default.json" {
+		t.Fatalf("expected gas to fall back to the default output path, got %+v", archive.Changes)
+	}
+
+	config.Destination.OutputPath = ""
+	if _, err := groupByDestination(config, results, "run"); err == nil || !strings.Contains(err.Error(), "query 'gas': no output_path configured") {
+		t.Fatalf("expected a query without an output path to be rejected, got %v", err)
+	}
+}
+
+func TestHandleCommitReportsCommittedBatchesOnFailure(t *testing.T) {
+	wadge.RunTest(t, func() {
+		fake := newFakeGitHub(map[string]string{})
+		git := httptest.NewServer(fake)
+		defer git.Close()
+		source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"total":1}`))
+		}))
+		defer source.Close()
+
+		t.Setenv("Q2GIT_GITHUB_TOKEN", "token")
+		t.Setenv("Q2GIT_CONFIG", `
+destination:
+  api_url: `+git.URL+`
+  owner: owner
+  repo: repo
+queries:
+  - name: power
+    url: `+source.URL+`
+    query: '.'
+    output_path: power.json
+  - name: water
+    url: `+source.URL+`
+    query: '.'
+    output_path: water.json
+    repo: missing
+`)
+
+		rec := httptest.NewRecorder()
+		HandleCommit(rec, httptest.NewRequest(http.MethodPost, "/api/commit", nil))
+
+		var response struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+			Commits []struct {
+				Repo   string `json:"repo"`
+				Status string `json:"status"`
+			} `json:"commits"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to decode response: %s", err)
+		}
+		if rec.Code != http.StatusNotFound || !strings.HasPrefix(response.Message, "owner/missing@main") {
+			t.Fatalf("expected the second batch to fail, got %d %s", rec.Code, rec.Body)
+		}
+		if len(response.Commits) != 1 || response.Commits[0].Repo != "owner/repo" || response.Commits[0].Status != "committed" {
+			t.Fatalf("expected the pushed first batch to be reported, got %+v", response.Commits)
+		}
+		if got, _ := fake.file("main", "power.json"); !strings.Contains(got, `"total": 1`) {
+			t.Fatalf("expected the first batch to be committed, got %q", got)
+		}
+	})
+}
+
+func TestWriteCommitSuccessKeepsSingleDestinationFields(t *testing.T) {
+	batches := []commitBatch{{Destination: DestinationConfig{Owner: "owner", Repo: "repo", Branch: "main", OutputPath: "data/{{.Query}}.json"}}}
+	results := []*CommitResult{{Branch: "main", SHA: "abc", Files: []FileResult{{Path: "data/power.json"}}}}
+
+	rec := httptest.NewRecorder()
+	writeCommitSuccess(rec, "run", batches, results)
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode response: %s", err)
+	}
+	if response["repo"] != "owner/repo" || response["branch"] != "main" || response["path"] != "data/power.json" {
+		t.Fatalf("expected the single-destination fields, got %v", response)
+	}
+	if commits, _ := response["commits"].([]interface{}); len(commits) != 1 {
+		t.Fatalf("expected one commit entry, got %v", response["commits"])
+	}
+}
+
+func TestGroupByDestinationRejectsSeveralFilesForBitbucketServer(t *testing.T) {
+	config := &Config{
+		Destination: DestinationConfig{Type: DestinationBitbucketServer, Owner: "PROJ", Repo: "repo", Branch: "main"},
+	}
+	results := []queryResult{
+		{Name: "power", Result: json.RawMessage(`1`), query: QueryConfig{OutputPath: "power.json"}},
+		{Name: "water", Result: json.RawMessage(`2`), query: QueryConfig{OutputPath: "water.json"}},
+	}
+	_, err := groupByDestination(config, results, "run")
+	if err == nil || !strings.Contains(err.Error(), "bitbucket_server commits one file at a time") {
+		t.Fatalf("expected several files on bitbucket server to be rejected, got %v", err)
+	}
+
+	results[1].query.Branch = "water"
+	if _, err := groupByDestination(config, results, "run"); err != nil {
+		t.Fatalf("expected one file per branch to be accepted, got %s", err)
+	}
+}
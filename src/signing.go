@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"golang.org/x/crypto/ssh"
+)
+
+// Signing formats accepted in DestinationConfig.Signing.
+const (
+	SigningSSH     = "ssh"
+	SigningOpenPGP = "openpgp"
+)
+
+// gitIdentity is a resolved author or committer line of a commit.
+type gitIdentity struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+// commitIdentities resolves the configured author and committer for a commit
+// made at now. The committer defaults to the author; nil leaves the choice to
+// the backend, which uses the token's identity.
+func commitIdentities(cfg *DestinationConfig, now time.Time) (author, committer *gitIdentity, err error) {
+	if author, err = resolveIdentity(cfg.Author, now); err != nil {
+		return nil, nil, fmt.Errorf("author: %w", err)
+	}
+	if committer, err = resolveIdentity(cfg.Committer, now); err != nil {
+		return nil, nil, fmt.Errorf("committer: %w", err)
+	}
+	if committer == nil {
+		committer = author
+	}
+	return author, committer, nil
+}
+
+func resolveIdentity(cfg IdentityConfig, now time.Time) (*gitIdentity, error) {
+	if cfg.Name == "" && cfg.Email == "" {
+		return nil, nil
+	}
+	if cfg.Name == "" || cfg.Email == "" {
+		return nil, fmt.Errorf("both name and email are required")
+	}
+
+	when := now.Truncate(time.Second)
+	if cfg.Date != "" {
+		var err error
+		if when, err = time.Parse(time.RFC3339, cfg.Date); err != nil {
+			return nil, fmt.Errorf("invalid date: %w", err)
+		}
+	}
+	return &gitIdentity{Name: cfg.Name, Email: cfg.Email, When: when}, nil
+}
+
+// gitLine formats the identity as in a raw commit object.
+func (id *gitIdentity) gitLine() string {
+	return fmt.Sprintf("%s <%s> %d %s", id.Name, id.Email, id.When.Unix(), id.When.Format("-0700"))
+}
+
+// apiFields formats the identity as the GitHub and Azure DevOps APIs take it.
+func (id *gitIdentity) apiFields() map[string]string {
+	return map[string]string{
+		"name":  id.Name,
+		"email": id.Email,
+		"date":  id.When.Format(time.RFC3339),
+	}
+}
+
+// commitObject builds the raw commit object git hashes and signs. The hosting
+// side must rebuild exactly these bytes for the signature to verify.
+func commitObject(treeSHA string, parents []string, author, committer *gitIdentity, message string) ([]byte, error) {
+	if author == nil || committer == nil {
+		return nil, fmt.Errorf("signed commits need an author and committer")
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "tree %s\n", treeSHA)
+	for _, parent := range parents {
+		fmt.Fprintf(&b, "parent %s\n", parent)
+	}
+	fmt.Fprintf(&b, "author %s\n", author.gitLine())
+	fmt.Fprintf(&b, "committer %s\n", committer.gitLine())
+	fmt.Fprintf(&b, "\n%s", message)
+	return b.Bytes(), nil
+}
+
+// signCommit returns an armored detached signature of payload, in the format
+
+// signCommit returns an armored detached signature of payload, in the format
+// git stores in the gpgsig header.
+func signCommit(format, key string, payload []byte, now time.Time) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("signing key not configured")
+	}
+	switch format {
+	case SigningSSH:
+		return sshSign(key, payload)
+	case SigningOpenPGP:
+		return openPGPSign(key, payload, now)
+	default:
+		return "", fmt.Errorf("unsupported signing format '%s'", format)
+	}
+}
+
+// sshsigMagic starts both the signed data and the blob of an SSHSIG
+// signature, see PROTOCOL.sshsig in OpenSSH.
+const sshsigMagic = "SSHSIG"
+
+// sshSign creates an SSHSIG signature in the "git" namespace, as
+// `ssh-keygen -Y sign -n git` does. The key must be an unencrypted private
+// key that golang.org/x/crypto/ssh can parse.
+func sshSign(key string, payload []byte) (string, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(key))
+	if err != nil {
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return "", fmt.Errorf("passphrase-protected signing keys are not supported")
+		}
+		return "", fmt.Errorf("failed to parse signing key: %w", err)
+	}
+
+	const namespace, hashAlg = "git", "sha512"
+	digest := sha512.Sum512(payload)
+	signed := append([]byte(sshsigMagic), ssh.Marshal(struct {
+		Namespace string
+		Reserved  string
+		HashAlg   string
+		Hash      []byte
+	}{namespace, "", hashAlg, digest[:]})...)
+
+	// ssh-keygen signs with SHA-512 rather than SHA-1 for RSA keys.
+	algorithm := signer.PublicKey().Type()
+	if algorithm == ssh.KeyAlgoRSA {
+		algorithm = ssh.KeyAlgoRSASHA512
+	}
+	algorithmSigner, ok := signer.(ssh.AlgorithmSigner)
+	if !ok {
+		return "", fmt.Errorf("unsupported SSH signing key type %s", signer.PublicKey().Type())
+	}
+	signature, err := algorithmSigner.SignWithAlgorithm(rand.Reader, signed, algorithm)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign: %w", err)
+	}
+
+	blob := append([]byte(sshsigMagic), ssh.Marshal(struct {
+		Version   uint32
+		PublicKey []byte
+		Namespace string
+		Reserved  string
+		HashAlg   string
+		Signature []byte
+	}{1, signer.PublicKey().Marshal(), namespace, "", hashAlg, ssh.Marshal(signature)})...)
+
+	// ssh-keygen wraps the base64 body at 70 columns, unlike PEM's 64.
+	var b strings.Builder
+	b.WriteString("-----BEGIN SSH SIGNATURE-----\n")
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	for len(encoded) > 70 {
+		b.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	b.WriteString(encoded + "\n")
+	b.WriteString("-----END SSH SIGNATURE-----\n")
+	return b.String(), nil
+}
+
+// openPGPSign creates a binary document signature with SHA-256, as
+// `gpg --detach-sign --armor` does, with the signing key of the first
+// unencrypted OpenPGP secret key in key.
+func openPGPSign(key string, payload []byte, now time.Time) (string, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(key))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse signing key: %w", err)
+	}
+	signer := entities[0]
+	if signer.PrivateKey == nil {
+		return "", fmt.Errorf("signing key holds no OpenPGP secret key")
+	}
+	if signer.PrivateKey.Encrypted {
+		return "", fmt.Errorf("passphrase-protected signing keys are not supported")
+	}
+
+	// Signatures stay deterministic for a given key, payload and time, as
+	// gpg's are, without the random salt notation go-crypto adds by default.
+	nonDeterministic := false
+	config := &packet.Config{
+		DefaultHash:                           crypto.SHA256,
+		Time:                                  func() time.Time { return now },
+		NonDeterministicSignaturesViaNotation: &nonDeterministic,
+	}
+	var signature strings.Builder
+	if err := openpgp.ArmoredDetachSign(&signature, signer, bytes.NewReader(payload), config); err != nil {
+		return "", fmt.Errorf("failed to sign: %w", err)
+	}
+	signature.WriteString("\n")
+	return signature.String(), nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Source auth types accepted in AuthConfig.Type.
+const (
+	AuthBasic  = "basic"
+	AuthBearer = "bearer"
+	AuthAPIKey = "api_key"
+	AuthOAuth2 = "oauth2"
+	// AuthProxy sends requests to a proxy on the host instead of the source
+	// and does no authentication itself. wasi:http outgoing requests take no
+	// TLS settings, so the component cannot present a client certificate;
+	// sources that need mTLS go through a proxy that does.
+	AuthProxy = "proxy"
+)
+
+// oauth2TokenLeeway is how long before its expiry a cached access token is
+// replaced.
+const oauth2TokenLeeway = time.Minute
+
+type oauth2Token struct {
+	token string
+	// expiresAt is zero when the server did not say; such tokens are kept
+	// until the source rejects them.
+	expiresAt time.Time
+}
+
+// oauth2Tokens caches client credentials access tokens across requests, keyed
+// by token URL, client and scopes.
+var oauth2Tokens = struct {
+	sync.Mutex
+	byKey map[string]oauth2Token
+}{byKey: map[string]oauth2Token{}}
+
+// configured reports whether auth is set at all, so that a query's auth
+// replaces its source's.
+func (a AuthConfig) configured() bool {
+	return a.Type != "" || a.UsernameEnv != "" || a.PasswordEnv != ""
+}
+
+func (a AuthConfig) validate() error {
+	switch a.Type {
+	case "", AuthBasic:
+		return nil
+	case AuthBearer:
+		if a.TokenEnv == "" {
+			return fmt.Errorf("bearer auth needs token_env")
+		}
+	case AuthAPIKey:
+		if a.KeyEnv == "" {
+			return fmt.Errorf("api_key auth needs key_env")
+		}
+		if a.Header != "" && a.QueryParam != "" {
+			return fmt.Errorf("api_key auth takes either header or query_param, not both")
+		}
+	case AuthOAuth2:
+		if a.TokenURL == "" || a.ClientIDEnv == "" || a.ClientSecretEnv == "" {
+			return fmt.Errorf("oauth2 auth needs token_url, client_id_env and client_secret_env")
+		}
+	case AuthProxy:
+		if a.ProxyURL == "" {
+			return fmt.Errorf("proxy auth needs proxy_url")
+		}
+		if _, err := a.proxyURL(); err != nil {
+			return err
+		}
+	case "mtls":
+		return fmt.Errorf("mtls auth is not supported: wasi:http cannot present a client certificate, use type proxy with a host-side proxy that presents it")
+	default:
+		return fmt.Errorf("unsupported auth type '%s'", a.Type)
+	}
+	return nil
+}
+
+// applyAuth authenticates a source request. Basic auth is only sent when both
+// credentials are set; the other types fail when their secret is missing.
+func applyAuth(req *http.Request, a *AuthConfig) error {
+	switch a.Type {
+	case "", AuthBasic:
+		if a.Username != "" && a.Password != "" {
+			req.SetBasicAuth(a.Username, a.Password)
+		}
+	case AuthBearer:
+		if a.Token == "" {
+			return fmt.Errorf("bearer token not configured: %s is not set", a.TokenEnv)
+		}
+		req.Header.Set("Authorization", "Bearer "+a.Token)
+	case AuthAPIKey:
+		if a.Key == "" {
+			return fmt.Errorf("API key not configured: %s is not set", a.KeyEnv)
+		}
+		if a.QueryParam != "" {
+			query := req.URL.Query()
+			query.Set(a.QueryParam, a.Key)
+			req.URL.RawQuery = query.Encode()
+			return nil
+		}
+		header := a.Header
+		if header == "" {
+			header = "X-API-Key"
+		}
+		req.Header.Set(header, a.Key)
+	case AuthOAuth2:
+		token, err := oauth2AccessToken(a)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case AuthProxy:
+		proxy, err := a.proxyURL()
+		if err != nil {
+			return err
+		}
+		// The proxy forwards to the source it is set up for; Host still
+		// names the source.
+		req.Host = req.URL.Host
+		req.URL.Scheme, req.URL.Host = proxy.Scheme, proxy.Host
+		if prefix := strings.TrimSuffix(proxy.Path, "/"); prefix != "" {
+			req.URL.Path = prefix + req.URL.Path
+			if req.URL.RawPath != "" {
+				req.URL.RawPath = prefix + req.URL.RawPath
+			}
+		}
+	default:
+		return a.validate()
+	}
+	return nil
+}
+
+func (a *AuthConfig) proxyURL() (*url.URL, error) {
+	u, err := url.Parse(a.ProxyURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("proxy auth proxy_url '%s' must be an http or https URL", a.ProxyURL)
+	}
+	return u, nil
+}
+
+func (a *AuthConfig) oauth2Key() string {
+	return a.TokenURL + "|" + a.ClientID + "|" + strings.Join(a.Scopes, " ")
+}
+
+// oauth2AccessToken returns a cached access token for the client
+// credentials, requesting a new one when it is about to expire.
+func oauth2AccessToken(a *AuthConfig) (string, error) {
+	if a.ClientID == "" || a.ClientSecret == "" {
+		return "", fmt.Errorf("oauth2 client credentials not configured: %s and %s must be set", a.ClientIDEnv, a.ClientSecretEnv)
+	}
+
+	key := a.oauth2Key()
+	oauth2Tokens.Lock()
+	defer oauth2Tokens.Unlock()
+
+	now := time.Now()
+	if cached, ok := oauth2Tokens.byKey[key]; ok && (cached.expiresAt.IsZero() || now.Add(oauth2TokenLeeway).Before(cached.expiresAt)) {
+		return cached.token, nil
+	}
+
+	token, err := requestOAuth2Token(a, now)
+	if err != nil {
+		return "", err
+	}
+	oauth2Tokens.byKey[key] = *token
+	return token.token, nil
+}
+
+// forgetOAuth2Token drops a cached access token the source rejected, so that
+// the next request fetches a fresh one.
+func forgetOAuth2Token(a *AuthConfig) {
+	oauth2Tokens.Lock()
+	defer oauth2Tokens.Unlock()
+	delete(oauth2Tokens.byKey, a.oauth2Key())
+}
+
+// requestOAuth2Token runs the client credentials grant (RFC 6749 section
+// 4.4), authenticating the client with basic auth.
+func requestOAuth2Token(a *AuthConfig, now time.Time) (*oauth2Token, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(a.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.Scopes, " "))
+	}
+	headers := map[string]string{
+		"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte(a.ClientID+":"+a.ClientSecret)),
+		"Content-Type":  "application/x-www-form-urlencoded",
+		"Accept":        "application/json",
+	}
+	body, _, err := apiRequest("POST", a.TokenURL, headers, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to request oauth2 token: %w", err)
+	}
+
+	var tokenData struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tokenData); err != nil {
+		return nil, fmt.Errorf("failed to decode oauth2 token response: %w", err)
+	}
+	if tokenData.AccessToken == "" {
+		return nil, fmt.Errorf("failed to request oauth2 token: empty token in response")
+	}
+	token := &oauth2Token{token: tokenData.AccessToken}
+	if tokenData.ExpiresIn > 0 {
+		token.expiresAt = now.Add(time.Duration(tokenData.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.wasmcloud.dev/wadge"
+)
+
+func TestFetchDataAuthenticatesSources(t *testing.T) {
+	tests := []struct {
+		name  string
+		auth  AuthConfig
+		check func(r *http.Request) bool
+	}{
+		{
+			name: "bearer",
+			auth: AuthConfig{Type: AuthBearer, Token: "s3cret"},
+			check: func(r *http.Request) bool {
+				return r.Header.Get("Authorization") == "Bearer s3cret"
+			},
+		},
+		{
+			name: "api key header",
+			auth: AuthConfig{Type: AuthAPIKey, Key: "k3y"},
+			check: func(r *http.Request) bool {
+				return r.Header.Get("X-API-Key") == "k3y"
+			},
+		},
+		{
+			name: "api key query parameter",
+			auth: AuthConfig{Type: AuthAPIKey, Key: "k3y", QueryParam: "apikey"},
+			check: func(r *http.Request) bool {
+				return r.URL.Query().Get("apikey") == "k3y" && r.URL.Query().Get("series") == "power"
+			},
+		},
+	}
+
+	wadge.RunTest(t, func() {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if !tt.check(r) {
+						http.Error(w, "unauthenticated", http.StatusUnauthorized)
+						return
+					}
+					_, _ = w.Write([]byte(`{}`))
+				}))
+				defer server.Close()
+
+				if _, err := FetchData(&SourceConfig{Method: http.MethodGet, Auth: tt.auth}, server.URL+"?series=power"); err != nil {
+					t.Fatalf("FetchData failed: %s", err)
+				}
+			})
+		}
+
+		_, err := FetchData(&SourceConfig{Method: http.MethodGet, Auth: AuthConfig{Type: AuthBearer, TokenEnv: "SOURCE_TOKEN"}}, "http://127.0.0.1:1")
+		if err == nil || !strings.Contains(err.Error(), "SOURCE_TOKEN is not set") {
+			t.Fatalf("expected a missing token to be reported, got %v", err)
+		}
+	})
+}
+
+func TestFetchDataCachesAndRefreshesOAuth2Tokens(t *testing.T) {
+	wadge.RunTest(t, func() {
+		issued := 0
+		revoked := map[string]bool{}
+		tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, secret, _ := r.BasicAuth()
+			if err := r.ParseForm(); err != nil || id != "client" || secret != "secret" ||
+				r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("scope") != "read metrics" {
+				http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+				return
+			}
+			issued++
+			writeFakeJSON(w, map[string]interface{}{
+				"access_token": fmt.Sprintf("token-%d", issued),
+				"token_type":   "Bearer",
+				"expires_in":   3600,
+			})
+		}))
+		defer tokenServer.Close()
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !strings.HasPrefix(token, "token-") || revoked[token] {
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte(`"` + token + `"`))
+		}))
+		defer server.Close()
+
+		source := &SourceConfig{Method: http.MethodGet, Auth: AuthConfig{
+			Type:         AuthOAuth2,
+			TokenURL:     tokenServer.URL,
+			Scopes:       []string{"read", "metrics"},
+			ClientID:     "client",
+			ClientSecret: "secret",
+		}}
+		for i := 0; i < 2; i++ {
+			data, err := FetchData(source, server.URL)
+			if err != nil {
+				t.Fatalf("FetchData failed: %s", err)
+			}
+			if string(data) != `"token-1"` || issued != 1 {
+				t.Fatalf("expected the token to be cached, got %s after %d tokens", data, issued)
+			}
+		}
+
+		// A token rejected before its expiry is replaced once.
+		revoked["token-1"] = true
+		data, err := FetchData(source, server.URL)
+		if err != nil {
+			t.Fatalf("FetchData failed: %s", err)
+		}
+		if string(data) != `"token-2"` || issued != 2 {
+			t.Fatalf("expected a fresh token, got %s after %d tokens", data, issued)
+		}
+	})
+}
+
+func TestFetchDataSendsProxySourcesThroughProxy(t *testing.T) {
+	wadge.RunTest(t, func() {
+		var got []string
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = append(got, r.Host+" "+r.URL.RequestURI())
+			if r.URL.Query().Get("page") == "" {
+				w.Header().Set("Link", `<https://metrics.example.com/api/series?page=2>; rel="next"`)
+			}
+			_, _ = w.Write([]byte(`[1]`))
+		}))
+		defer proxy.Close()
+
+		source := &SourceConfig{
+			Method:     http.MethodGet,
+			Auth:       AuthConfig{Type: AuthProxy, ProxyURL: proxy.URL + "/metrics/"},
+			Pagination: &PaginationConfig{Type: PaginationLink},
+		}
+		data, err := FetchData(source, "https://metrics.example.com/api/series")
+		if err != nil {
+			t.Fatalf("FetchData failed: %s", err)
+		}
+		want := []string{
+			"metrics.example.com /metrics/api/series",
+			"metrics.example.com /metrics/api/series?page=2",
+		}
+		if string(data) != "[1,1]" || strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Fatalf("expected both pages through the proxy, got %s from %q", data, got)
+		}
+	})
+}
+
+func TestLoadConfigValidatesSourceAuth(t *testing.T) {
+	for _, auth := range []string{
+		"{type: bearer}",
+		"{type: api_key, key_env: KEY, header: X-Key, query_param: key}",
+		"{type: oauth2, token_url: https://auth.example.com/token}",
+		"{type: proxy}",
+		"{type: proxy, proxy_url: 'unix:///run/proxy.sock'}",
+		"{type: mtls, proxy_url: http://127.0.0.1:8443}",
+		"{type: digest}",
+	} {
+		t.Setenv("Q2GIT_CONFIG", "sources:\n  api:\n    auth: "+auth+"\n")
+		if _, err := LoadConfig(); err == nil {
+			t.Errorf("expected auth %s to be rejected", auth)
+		}
+	}
+
+	t.Setenv("API_TOKEN", "s3cret")
+	t.Setenv("Q2GIT_CONFIG", "source:\n  auth: {type: bearer, token_env: API_TOKEN}\n")
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %s", err)
+	}
+	if config.Source.Auth.Token != "s3cret" || config.Source.Auth.UsernameEnv != "" {
+		t.Fatalf("expected bearer auth without the basic-auth defaults, got %+v", config.Source.Auth)
+	}
+}
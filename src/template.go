@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateData is what output path and commit message templates see, e.g.
+// `readings/{{.Date "2006/01"}}/{{.Query}}.csv` or
+// `power: {{.Result.total}} kWh`.
+type templateData struct {
+	// Query is the query name, or the comma-separated names of all queries
+	// in a commit.
+	Query   string
+	Queries []string
+	RunID   string
+	// Hash is the hex SHA-256 of the query output, or of all outputs in a
+	// commit.
+	Hash string
+	// Result is the decoded query result. In commit messages it is only set
+	// when the commit holds a single query; Results has them all by name.
+	Result    interface{}
+	Results   map[string]interface{}
+	Timestamp string
+
+	now time.Time
+}
+
+func newTemplateData(now time.Time, runID string) *templateData {
+	return &templateData{
+		RunID:     runID,
+		Results:   map[string]interface{}{},
+		Timestamp: now.Format(time.RFC3339),
+		now:       now,
+	}
+}
+
+// Date formats the run time, in UTC, with a Go time layout.
+func (d *templateData) Date(layout string) string {
+	return d.now.UTC().Format(layout)
+}
+
+// JQ evaluates a jq expression against Result.
+func (d *templateData) JQ(expr string) (interface{}, error) {
+	e, err := compileExpression(expr)
+	if err != nil {
+		return nil, err
+	}
+	return e.Eval(d.Result)
+}
+
+// renderTemplate executes text as a text/template. Referencing a missing map
+// key, such as an absent result field, is an error.
+func renderTemplate(name, text string, data *templateData) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s template: %w", name, err)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("failed to render %s template: %w", name, err)
+	}
+	return out.String(), nil
+}
+
+// renderOutputPath renders an output path template and makes sure the result
+// stays inside the repository. Leading and trailing slashes are dropped;
+// empty, "." and ".." segments, e.g. from an empty result field, are an
+// error rather than silently moving the file elsewhere.
+func renderOutputPath(text string, data *templateData) (string, error) {
+	rendered, err := renderTemplate("output_path", text, data)
+	if err != nil {
+		return "", err
+	}
+	trimmed := strings.Trim(rendered, "/")
+	for _, segment := range strings.Split(trimmed, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", fmt.Errorf("invalid output_path '%s'", rendered)
+		}
+	}
+	return trimmed, nil
+}
+
+// decodeResult decodes a query result for templates, keeping numbers as
+// written so that large integers are not printed in exponent form.
+func decodeResult(result json.RawMessage) interface{} {
+	var value interface{}
+	dec := json.NewDecoder(bytes.NewReader(result))
+	dec.UseNumber()
+	if err := dec.Decode(&value); err != nil {
+		return nil
+	}
+	return value
+}
+
+func contentHash(chunks ...[]byte) string {
+	h := sha256.New()
+	for _, chunk := range chunks {
+		h.Write(chunk)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// newRunID identifies one /api/commit call, e.g. "20261016T120000Z-3f9a1c".
+func newRunID(now time.Time) string {
+	return fmt.Sprintf("%s-%06x", now.UTC().Format("20060102T150405Z"), rand.Intn(1<<24))
+}
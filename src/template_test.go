@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestTemplateData(result string) *templateData {
+	data := newTemplateData(time.Date(2026, 10, 16, 12, 30, 0, 0, time.FixedZone("CEST", 2*3600)), "run-1")
+	data.Query = "power"
+	data.Result = decodeResult(json.RawMessage(result))
+	return data
+}
+
+func TestRenderTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		result  string
+		want    string
+		wantErr string
+	}{
+		{name: "plain text", text: "update data", want: "update data"},
+		{name: "query and run", text: "{{.Query}} {{.RunID}}", want: "power run-1"},
+		{name: "date in utc", text: `{{.Date "2006/01/02 15:04"}}`, want: "2026/10/16 10:30"},
+		{name: "date with zone", text: `{{.Date "2006-01-02T15:04Z07:00"}}`, want: "2026-10-16T10:30Z"},
+		{name: "timestamp", text: "{{.Timestamp}}", want: "2026-10-16T12:30:00+02:00"},
+		{name: "result field", text: "total {{.Result.total}}", result: `{"total": 12345678901234567890}`, want: "total 12345678901234567890"},
+		{name: "missing result field", text: "{{.Result.missing}}", result: `{"total": 1}`, wantErr: `map has no entry for key "missing"`},
+		{name: "jq", text: `{{.JQ ".items | length"}}`, result: `{"items": [1, 2, 3]}`, want: "3"},
+		{name: "jq parse error", text: `{{.JQ ".items |"}}`, result: `{}`, wantErr: "failed to parse jq expression"},
+		{name: "jq runtime error", text: `{{.JQ ".name | tonumber"}}`, result: `{"name": "kWh"}`, wantErr: "jq expression '.name | tonumber' failed"},
+		{name: "jq without output", text: `{{.JQ "empty"}}`, result: `{}`, wantErr: "produced no output"},
+		{name: "parse error", text: "{{.Query", wantErr: "failed to parse test template"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.result
+			if result == "" {
+				result = "null"
+			}
+			got, err := renderTemplate("test", tt.text, newTestTemplateData(result))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("renderTemplate failed: %s", err)
+			}
+			if got != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRenderOutputPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		result  string
+		want    string
+		wantErr bool
+	}{
+		{name: "plain path", text: "data/power.csv", want: "data/power.csv"},
+		{name: "templated path", text: `readings/{{.Date "2006/01"}}/{{.Query}}.csv`, want: "readings/2026/10/power.csv"},
+		{name: "absolute path", text: "/data/power.csv", want: "data/power.csv"},
+		{name: "result segment", text: "sites/{{.Result.site}}/power.csv", result: `{"site": "north"}`, want: "sites/north/power.csv"},
+		{name: "parent directory", text: "../power.csv", wantErr: true},
+		{name: "parent directory inside", text: "data/../../power.csv", wantErr: true},
+		{name: "parent directory from result", text: "sites/{{.Result.site}}/power.csv", result: `{"site": ".."}`, wantErr: true},
+		{name: "current directory", text: "./power.csv", wantErr: true},
+		{name: "empty segment from result", text: "sites/{{.Result.site}}/power.csv", result: `{"site": ""}`, wantErr: true},
+		{name: "empty path", text: "{{.Result.site}}", result: `{"site": ""}`, wantErr: true},
+		{name: "root only", text: "/", wantErr: true},
+		{name: "missing result field", text: "sites/{{.Result.site}}/power.csv", result: `{}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.result
+			if result == "" {
+				result = "null"
+			}
+			got, err := renderOutputPath(tt.text, newTestTemplateData(result))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("renderOutputPath failed: %s", err)
+			}
+			if got != tt.want {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	// The SHA-256 of "abc", split across chunks.
+	const want = "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	if got := contentHash([]byte("a"), nil, []byte("bc")); got != want {
+		t.Fatalf("unexpected hash %s", got)
+	}
+}
+
+func TestNewRunID(t *testing.T) {
+	now := time.Date(2026, 10, 16, 14, 0, 0, 0, time.FixedZone("CEST", 2*3600))
+	id := newRunID(now)
+	if !regexp.MustCompile(`^20261016T120000Z-[0-9a-f]{6}$`).MatchString(id) {
+		t.Fatalf("unexpected run ID %q", id)
+	}
+}
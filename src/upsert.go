@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/itchyny/gojq"
+)
+
+// upsertFormat picks the record format of an upsert file: CSV rows for .csv,
+// otherwise JSON records.
+func upsertFormat(outputPath string) string {
+	if strings.ToLower(path.Ext(outputPath)) == ".csv" {
+		return recordsCSV
+	}
+	return jsonRecordFormat(outputPath)
+}
+
+// mergeUpsert replaces the records of existing whose key matches an incoming
+// record and adds the others. Records keep their position unless sort_by is
+// set; duplicate keys already in the file are collapsed as well.
+func mergeUpsert(change FileChange, existing []byte) ([]byte, error) {
+	if change.Key == "" {
+		return nil, fmt.Errorf("upsert needs a key expression")
+	}
+	key, err := compileExpression(change.Key)
+	if err != nil {
+		return nil, err
+	}
+	var sortBy *jqExpression
+	if change.SortBy != "" {
+		if sortBy, err = compileExpression(change.SortBy); err != nil {
+			return nil, err
+		}
+	}
+
+	format := upsertFormat(change.Path)
+	file, err := parseRecords(format, existing, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read existing records: %w", err)
+	}
+
+	if format == recordsCSV {
+		err = file.addCSV(change.Content)
+	} else {
+		err = file.addJSONValues(change.Content)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if file.records, err = upsertRecords(file.records, key, sortBy); err != nil {
+		return nil, err
+	}
+	if format == recordsCSV && file.header == nil {
+		return existing, nil
+	}
+	return file.encode()
+}
+
+// addJSONValues adds a stream of JSON values as records, spreading arrays.
+func (f *recordFile) addJSONValues(data []byte) error {
+	values, err := jsonValues(data, true)
+	if err != nil {
+		return err
+	}
+	for _, value := range values {
+		if err := f.addJSON(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addCSV adds the rows of CSV data with a header line, reordering its columns
+// to the file's header if there is one.
+func (f *recordFile) addCSV(data []byte) error {
+	rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return fmt.Errorf("invalid CSV result: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	added := rows[1:]
+	if f.header == nil {
+		f.header = rows[0]
+	} else if added, err = reorderCSV(f.header, rows[0], added); err != nil {
+		return err
+	}
+	for _, row := range added {
+		f.addRow(row)
+	}
+	return nil
+}
+
+// upsertRecords keeps the last record for every key, at the position the key
+// was first seen, then sorts the result stably by sortBy if set.
+func upsertRecords(records []record, key, sortBy *jqExpression) ([]record, error) {
+	merged := make([]record, 0, len(records))
+	index := map[string]int{}
+	for _, r := range records {
+		k, err := key.Eval(r.value)
+		if err != nil {
+			return nil, err
+		}
+		id, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+
+		if i, ok := index[string(id)]; ok {
+			merged[i] = r
+			continue
+		}
+		index[string(id)] = len(merged)
+		merged = append(merged, r)
+	}
+
+	if sortBy == nil {
+		return merged, nil
+	}
+	for i := range merged {
+		k, err := sortBy.Eval(merged[i].value)
+		if err != nil {
+			return nil, err
+		}
+		merged[i].sortKey = k
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return gojq.Compare(merged[i].sortKey, merged[j].sortKey) < 0
+	})
+	return merged, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMergeUpsert(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		key      string
+		sortBy   string
+		existing string
+		incoming string
+		want     string
+		wantErr  string
+	}{
+		{
+			name:     "replaces a matching record in place",
+			path:     "data.json",
+			key:      ".id",
+			existing: `[{"id":1,"v":"a"},{"id":2,"v":"b"},{"id":3,"v":"c"}]`,
+			incoming: `{"id":2,"v":"B"}`,
+			want:     "[\n  {\"id\":1,\"v\":\"a\"},\n  {\"id\":2,\"v\":\"B\"},\n  {\"id\":3,\"v\":\"c\"}\n]\n",
+		},
+		{
+			name:     "inserts new keys at the end",
+			path:     "data.jsonl",
+			key:      ".id",
+			existing: "{\"id\":1}\n",
+			incoming: "{\"id\":3}\n{\"id\":2}\n",
+			want:     "{\"id\":1}\n{\"id\":3}\n{\"id\":2}\n",
+		},
+		{
+			name:     "matches keys by JSON value",
+			path:     "data.jsonl",
+			key:      ".id",
+			existing: "{\"id\":1,\"v\":\"number\"}\n",
+			incoming: `{"id":"1","v":"string"}`,
+			want:     "{\"id\":1,\"v\":\"number\"}\n{\"id\":\"1\",\"v\":\"string\"}\n",
+		},
+		{
+			name:     "composite keys",
+			path:     "data.jsonl",
+			key:      "[.host, .day]",
+			existing: "{\"host\":\"a\",\"day\":1,\"v\":1}\n{\"host\":\"b\",\"day\":1,\"v\":1}\n",
+			incoming: `{"host":"b","day":1,"v":2}`,
+			want:     "{\"host\":\"a\",\"day\":1,\"v\":1}\n{\"host\":\"b\",\"day\":1,\"v\":2}\n",
+		},
+		{
+			name:     "last incoming record wins",
+			path:     "data.jsonl",
+			key:      ".id",
+			incoming: `[{"id":1,"v":"first"},{"id":1,"v":"last"}]`,
+			want:     "{\"id\":1,\"v\":\"last\"}\n",
+		},
+		{
+			name:     "sort_by keeps ties in order",
+			path:     "data.jsonl",
+			key:      ".id",
+			sortBy:   ".day",
+			existing: "{\"id\":\"x\",\"day\":2}\n{\"id\":\"y\",\"day\":1}\n",
+			incoming: `[{"id":"z","day":2},{"id":"w","day":1}]`,
+			want:     "{\"id\":\"y\",\"day\":1}\n{\"id\":\"w\",\"day\":1}\n{\"id\":\"x\",\"day\":2}\n{\"id\":\"z\",\"day\":2}\n",
+		},
+		{
+			name:     "sort_by descending",
+			path:     "data.jsonl",
+			key:      ".id",
+			sortBy:   "-.id",
+			existing: "{\"id\":1}\n{\"id\":3}\n",
+			incoming: `{"id":2}`,
+			want:     "{\"id\":3}\n{\"id\":2}\n{\"id\":1}\n",
+		},
+		{
+			name:     "csv keeps the existing header and matches rows by key",
+			path:     "data.csv",
+			key:      ".date",
+			existing: "date,kwh\n2024-01-01,4\n2024-01-02,5\n",
+			incoming: "kwh,date\n6,2024-01-02\n7,2024-01-03\n",
+			want:     "date,kwh\n2024-01-01,4\n2024-01-02,6\n2024-01-03,7\n",
+		},
+		{
+			name:     "csv into a new file takes the incoming header",
+			path:     "DATA.CSV",
+			key:      ".date",
+			incoming: "date,kwh\n2024-01-01,4\n2024-01-01,5\n",
+			want:     "date,kwh\n2024-01-01,5\n",
+		},
+		{
+			name:     "csv with an empty result keeps the records",
+			path:     "data.csv",
+			key:      ".date",
+			existing: "date,kwh\n2024-01-01,4",
+			want:     "date,kwh\n2024-01-01,4\n",
+		},
+		{
+			name:     "csv with other columns",
+			path:     "data.csv",
+			key:      ".date",
+			existing: "date,kwh\n2024-01-01,4\n",
+			incoming: "date,m3\n2024-01-02,1\n",
+			wantErr:  "do not match existing header",
+		},
+		{
+			name:     "no key",
+			path:     "data.json",
+			incoming: `{}`,
+			wantErr:  "needs a key",
+		},
+		{
+			name:     "invalid key",
+			path:     "data.json",
+			key:      ".id |",
+			incoming: `{}`,
+			wantErr:  "failed to parse jq expression",
+		},
+		{
+			name:     "key failing on a record",
+			path:     "data.csv",
+			key:      ".kwh + 1",
+			incoming: "date,kwh\n2024-01-01,4\n",
+			wantErr:  "jq expression '.kwh + 1' failed",
+		},
+		{
+			name:     "sort_by failing on a record",
+			path:     "data.jsonl",
+			key:      ".id",
+			sortBy:   ".day | tonumber",
+			incoming: `{"id":1,"day":"monday"}`,
+			wantErr:  "jq expression '.day | tonumber' failed",
+		},
+		{
+			name:     "invalid existing records",
+			path:     "data.json",
+			key:      ".id",
+			existing: `[{"id":1}`,
+			incoming: `{"id":2}`,
+			wantErr:  "failed to read existing records",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var existing []byte
+			if tt.existing != "" {
+				existing = []byte(tt.existing)
+			}
+			change := FileChange{Path: tt.path, Content: []byte(tt.incoming), WriteMode: WriteModeUpsert, Key: tt.key, SortBy: tt.sortBy}
+			got, err := mergeUpsert(change, existing)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("mergeUpsert failed: %s", err)
+			}
+			if string(got) != tt.want {
+				t.Fatalf("unexpected content:\nwant %q\ngot  %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestUpsertFormat(t *testing.T) {
+	for path, want := range map[string]string{
+		"data.csv":       recordsCSV,
+		"data.CSV":       recordsCSV,
+		"data.json":      recordsJSON,
+		"data.ndjson":    recordsNDJSON,
+		"data.jsonl":     recordsNDJSON,
+		"data/no-suffix": recordsJSON,
+	} {
+		if got := upsertFormat(path); got != want {
+			t.Errorf("%s: expected %s, got %s", path, want, got)
+		}
+	}
+}
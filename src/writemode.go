@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Write modes accepted in SettingsConfig.WriteMode and QueryConfig.WriteMode.
+const (
+	WriteModeOverwrite = "overwrite"
+	// WriteModeAppend concatenates the raw bytes.
+	WriteModeAppend = "append"
+	// WriteModeJSONArrayAppend adds the result, or each element of an array
+	// result, to a JSON array.
+	WriteModeJSONArrayAppend = "json_array_append"
+	// WriteModeJSONMerge deep-merges a JSON object result into a JSON object.
+	WriteModeJSONMerge = "json_merge"
+	// WriteModeNDJSONAppend adds the result, or each element of an array
+	// result, as one line of newline-delimited JSON.
+	WriteModeNDJSONAppend = "ndjson_append"
+	// WriteModeCSVAppend adds the rows of a CSV result, whose first line is the
+	// header, writing the header only once.
+	WriteModeCSVAppend = "csv_append"
+	// WriteModeUpsert replaces or inserts records matched by a key
+	// expression; see mergeUpsert.
+	WriteModeUpsert = "upsert"
+	// WriteModeAuto picks a mode from the output file extension.
+	WriteModeAuto = "auto"
+)
+
+// resolveWriteMode validates mode and resolves "auto" for the given output
+// path. An empty mode overwrites.
+func resolveWriteMode(mode, outputPath string) (string, error) {
+	switch mode {
+	case "":
+		return WriteModeOverwrite, nil
+	case WriteModeAuto:
+		switch strings.ToLower(path.Ext(outputPath)) {
+		case ".json":
+			return WriteModeJSONArrayAppend, nil
+		case ".ndjson", ".jsonl":
+			return WriteModeNDJSONAppend, nil
+		case ".csv":
+			return WriteModeCSVAppend, nil
+		default:
+			return WriteModeAppend, nil
+		}
+	case WriteModeOverwrite, WriteModeAppend, WriteModeJSONArrayAppend,
+		WriteModeJSONMerge, WriteModeNDJSONAppend, WriteModeCSVAppend, WriteModeUpsert:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unsupported write_mode '%s'", mode)
+	}
+}
+
+// isJSONWriteMode reports whether mode consumes query results for outputPath
+// as JSON values rather than as text.
+func isJSONWriteMode(mode, outputPath string) bool {
+	switch mode {
+	case WriteModeJSONArrayAppend, WriteModeJSONMerge, WriteModeNDJSONAppend:
+		return true
+	case WriteModeUpsert:
+		return upsertFormat(outputPath) != recordsCSV
+	}
+	return false
+}
+
+// isCSVWriteMode reports whether mode consumes query results for outputPath
+// as CSV with a header line.
+func isCSVWriteMode(mode, outputPath string) bool {
+	return mode == WriteModeCSVAppend || (mode == WriteModeUpsert && upsertFormat(outputPath) == recordsCSV)
+}
+
+// combineResults joins the results of several queries writing to the same
+// path, before they are merged with the file on the branch.
+func combineResults(mode, outputPath string, first, next []byte) ([]byte, error) {
+	switch {
+	case isCSVWriteMode(mode, outputPath):
+		return mergeCSV(first, next)
+	case isJSONWriteMode(mode, outputPath):
+		// JSON results are read back as a stream of values.
+		return append(append(first, '\n'), next...), nil
+	default:
+		return append(first, next...), nil
+	}
+}
+
+// mergeContent applies the write mode of change to the existing file
+// content, nil if the file is absent, and the incoming query output.
+func mergeContent(change FileChange, existing []byte) ([]byte, error) {
+	incoming := change.Content
+	switch mode := change.WriteMode; mode {
+	case "", WriteModeOverwrite:
+		return incoming, nil
+	case WriteModeAppend:
+		return append(append([]byte(nil), existing...), incoming...), nil
+	case WriteModeJSONArrayAppend:
+		return mergeJSONArray(existing, incoming)
+	case WriteModeJSONMerge:
+		return mergeJSONObject(existing, incoming)
+	case WriteModeNDJSONAppend:
+		return mergeNDJSON(existing, incoming)
+	case WriteModeCSVAppend:
+		return mergeCSV(existing, incoming)
+	case WriteModeUpsert:
+		return mergeUpsert(change, existing)
+	default:
+		return nil, fmt.Errorf("unsupported write_mode '%s'", mode)
+	}
+}
+
+// jsonValues decodes a stream of JSON values. Top-level arrays are flattened
+// into their elements when spread is set.
+func jsonValues(data []byte, spread bool) ([]json.RawMessage, error) {
+	var values []json.RawMessage
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var value json.RawMessage
+		if err := dec.Decode(&value); err == io.EOF {
+			return values, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+
+		if spread && bytes.HasPrefix(value, []byte("[")) {
+			var elements []json.RawMessage
+			if err := json.Unmarshal(value, &elements); err != nil {
+				return nil, err
+			}
+			values = append(values, elements...)
+			continue
+		}
+		values = append(values, value)
+	}
+}
+
+// mergeJSONArray writes one array element per line, so that appends show up
+// as added lines in diffs.
+func mergeJSONArray(existing, incoming []byte) ([]byte, error) {
+	var elements []json.RawMessage
+	if len(bytes.TrimSpace(existing)) > 0 {
+		if err := json.Unmarshal(existing, &elements); err != nil {
+			return nil, fmt.Errorf("existing file is not a JSON array: %w", err)
+		}
+	}
+
+	values, err := jsonValues(incoming, true)
+	if err != nil {
+		return nil, err
+	}
+	return encodeJSONArray(append(elements, values...))
+}
+
+// encodeJSONArray writes one compact element per line.
+func encodeJSONArray(elements []json.RawMessage) ([]byte, error) {
+	var out bytes.Buffer
+	out.WriteString("[")
+	for i, element := range elements {
+		if i > 0 {
+			out.WriteString(",")
+		}
+		out.WriteString("\n  ")
+		if err := json.Compact(&out, element); err != nil {
+			return nil, err
+		}
+	}
+	if len(elements) > 0 {
+		out.WriteString("\n")
+	}
+	out.WriteString("]\n")
+	return out.Bytes(), nil
+}
+
+func mergeJSONObject(existing, incoming []byte) ([]byte, error) {
+	merged := map[string]interface{}{}
+	if len(bytes.TrimSpace(existing)) > 0 {
+		if err := decodeJSONObject(existing, &merged); err != nil {
+			return nil, fmt.Errorf("existing file is not a JSON object: %w", err)
+		}
+	}
+
+	values, err := jsonValues(incoming, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, value := range values {
+		var object map[string]interface{}
+		if err := decodeJSONObject(value, &object); err != nil {
+			return nil, fmt.Errorf("json_merge needs a JSON object result: %w", err)
+		}
+		deepMerge(merged, object)
+	}
+
+	out, err := json.MarshalIndent(merged, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// decodeJSONObject decodes numbers as json.Number so they are written back
+// exactly as read.
+func decodeJSONObject(data []byte, object *map[string]interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(object); err != nil {
+		return err
+	}
+	if *object == nil {
+		return fmt.Errorf("got null")
+	}
+	return nil
+}
+
+// deepMerge copies src into dst. Nested objects are merged key by key; any
+// other value in src replaces the one in dst.
+func deepMerge(dst, src map[string]interface{}) {
+	for key, value := range src {
+		srcObject, srcOK := value.(map[string]interface{})
+		dstObject, dstOK := dst[key].(map[string]interface{})
+		if srcOK && dstOK {
+			deepMerge(dstObject, srcObject)
+			continue
+		}
+		dst[key] = value
+	}
+}
+
+func mergeNDJSON(existing, incoming []byte) ([]byte, error) {
+	values, err := jsonValues(incoming, true)
+	if err != nil {
+		return nil, err
+	}
+	return encodeNDJSON(existing, values)
+}
+
+// encodeNDJSON appends one compact value per line to the lines in prefix.
+func encodeNDJSON(prefix []byte, values []json.RawMessage) ([]byte, error) {
+	out := bytes.NewBuffer(append([]byte(nil), prefix...))
+	if out.Len() > 0 && !bytes.HasSuffix(prefix, []byte("\n")) {
+		out.WriteString("\n")
+	}
+	for _, value := range values {
+		if err := json.Compact(out, value); err != nil {
+			return nil, err
+		}
+		out.WriteString("\n")
+	}
+	return out.Bytes(), nil
+}
+
+// mergeCSV appends the rows of incoming to existing. The existing rows are
+// kept byte for byte; incoming columns are reordered to the existing header
+// and must match it as a set.
+func mergeCSV(existing, incoming []byte) ([]byte, error) {
+	records, err := csv.NewReader(bytes.NewReader(incoming)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV result: %w", err)
+	}
+	if len(records) == 0 {
+		return existing, nil
+	}
+	header, rows := records[0], records[1:]
+
+	out := bytes.NewBuffer(append([]byte(nil), existing...))
+	w := csv.NewWriter(out)
+
+	if len(bytes.TrimSpace(existing)) == 0 {
+		out.Reset()
+		if err := w.Write(header); err != nil {
+			return nil, err
+		}
+	} else {
+		current, err := csv.NewReader(bytes.NewReader(existing)).Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read existing CSV header: %w", err)
+		}
+		if rows, err = reorderCSV(current, header, rows); err != nil {
+			return nil, err
+		}
+		if !bytes.HasSuffix(existing, []byte("\n")) {
+			out.WriteString("\n")
+		}
+	}
+
+	if err := w.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// reorderCSV maps rows with the given header onto the columns of want.
+func reorderCSV(want, header []string, rows [][]string) ([][]string, error) {
+	mismatch := fmt.Errorf("CSV columns [%s] do not match existing header [%s]",
+		strings.Join(header, ","), strings.Join(want, ","))
+	if len(header) != len(want) {
+		return nil, mismatch
+	}
+
+	index := make(map[string]int, len(header))
+	for i, column := range header {
+		index[column] = i
+	}
+	order := make([]int, len(want))
+	for i, column := range want {
+		j, ok := index[column]
+		if !ok {
+			return nil, mismatch
+		}
+		order[i] = j
+	}
+
+	reordered := make([][]string, len(rows))
+	for r, row := range rows {
+		reordered[r] = make([]string, len(order))
+		for i, j := range order {
+			reordered[r][i] = row[j]
+		}
+	}
+	return reordered, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMergeContent(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		mode     string
+		path     string
+		key      string
+		sortBy   string
+		existing string
+		incoming string
+		want     string
+		err      string
+	}{
+		{
+			name:     "json array from scratch",
+			mode:     WriteModeJSONArrayAppend,
+			incoming: `{"a":1}`,
+			want:     "[\n  {\"a\":1}\n]\n",
+		},
+		{
+			name:     "json array spreads array results",
+			mode:     WriteModeJSONArrayAppend,
+			existing: "[\n  {\"a\":1}\n]\n",
+			incoming: `[{"a": 2}, {"a": 3}]`,
+			want:     "[\n  {\"a\":1},\n  {\"a\":2},\n  {\"a\":3}\n]\n",
+		},
+		{
+			name:     "json array rejects other files",
+			mode:     WriteModeJSONArrayAppend,
+			existing: `{"a":1}`,
+			incoming: `{"a":2}`,
+			err:      "not a JSON array",
+		},
+		{
+			name:     "json merge is deep",
+			mode:     WriteModeJSONMerge,
+			existing: `{"a":{"x":1,"y":2},"b":[1]}`,
+			incoming: `{"a":{"y":3},"b":[2],"c":1.50}`,
+			want:     "{\n  \"a\": {\n    \"x\": 1,\n    \"y\": 3\n  },\n  \"b\": [\n    2\n  ],\n  \"c\": 1.50\n}\n",
+		},
+		{
+			name:     "json merge needs objects",
+			mode:     WriteModeJSONMerge,
+			incoming: `[1]`,
+			err:      "needs a JSON object",
+		},
+		{
+			name:     "ndjson",
+			mode:     WriteModeNDJSONAppend,
+			existing: `{"a":1}`,
+			incoming: "[{\"a\": 2}, 3]\n\"x\"",
+			want:     "{\"a\":1}\n{\"a\":2}\n3\n\"x\"\n",
+		},
+		{
+			name:     "csv from scratch",
+			mode:     WriteModeCSVAppend,
+			incoming: "ts,value\n1,a\n",
+			want:     "ts,value\n1,a\n",
+		},
+		{
+			name:     "csv writes the header once and reorders columns",
+			mode:     WriteModeCSVAppend,
+			existing: "ts,value\n1,a",
+			incoming: "value,ts\n\"b,c\",2\n",
+			want:     "ts,value\n1,a\n2,\"b,c\"\n",
+		},
+		{
+			name:     "csv rejects other columns",
+			mode:     WriteModeCSVAppend,
+			existing: "ts,value\n1,a\n",
+			incoming: "ts,other\n2,b\n",
+			err:      "do not match existing header",
+		},
+		{
+			name:     "upsert json array replaces matching records",
+			mode:     WriteModeUpsert,
+			path:     "data.json",
+			key:      ".ts",
+			existing: "[\n  {\"ts\":1,\"v\":\"a\"},\n  {\"ts\":2,\"v\":\"b\"}\n]\n",
+			incoming: `[{"ts":2,"v":"B"},{"ts":3,"v":"c"}]`,
+			want:     "[\n  {\"ts\":1,\"v\":\"a\"},\n  {\"ts\":2,\"v\":\"B\"},\n  {\"ts\":3,\"v\":\"c\"}\n]\n",
+		},
+		{
+			name:     "upsert ndjson sorts and collapses duplicates",
+			mode:     WriteModeUpsert,
+			path:     "data.ndjson",
+			key:      "[.host, .ts]",
+			sortBy:   "-.ts",
+			existing: "{\"host\":\"a\",\"ts\":1}\n{\"host\":\"a\",\"ts\":1,\"dup\":true}\n",
+			incoming: `{"host":"a","ts":2}`,
+			want:     "{\"host\":\"a\",\"ts\":2}\n{\"host\":\"a\",\"ts\":1,\"dup\":true}\n",
+		},
+		{
+			name:     "upsert csv",
+			mode:     WriteModeUpsert,
+			path:     "data.csv",
+			key:      ".date",
+			sortBy:   ".date",
+			existing: "date,kwh\n2024-01-02,5\n2024-01-03,7\n",
+			incoming: "kwh,date\n6,2024-01-03\n4,2024-01-01\n",
+			want:     "date,kwh\n2024-01-01,4\n2024-01-02,5\n2024-01-03,6\n",
+		},
+		{
+			name:     "upsert needs a key",
+			mode:     WriteModeUpsert,
+			path:     "data.json",
+			incoming: `{}`,
+			err:      "needs a key",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var existing []byte
+			if tc.existing != "" {
+				existing = []byte(tc.existing)
+			}
+			change := FileChange{Path: tc.path, Content: []byte(tc.incoming), WriteMode: tc.mode, Key: tc.key, SortBy: tc.sortBy}
+			got, err := mergeContent(change, existing)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("mergeContent failed: %s", err)
+			}
+			if string(got) != tc.want {
+				t.Fatalf("unexpected content:\nwant %q\ngot  %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestResolveWriteModeFromExtension(t *testing.T) {
+	for path, want := range map[string]string{
+		"out/data.json":  WriteModeJSONArrayAppend,
+		"out/data.JSONL": WriteModeNDJSONAppend,
+		"data.ndjson":    WriteModeNDJSONAppend,
+		"data.csv":       WriteModeCSVAppend,
+		"data.txt":       WriteModeAppend,
+	} {
+		got, err := resolveWriteMode(WriteModeAuto, path)
+		if err != nil || got != want {
+			t.Fatalf("%s: want %s, got %s (%v)", path, want, got, err)
+		}
+	}
+	if _, err := resolveWriteMode("prepend", "data.txt"); err == nil {
+		t.Fatalf("expected an unknown write mode to be rejected")
+	}
+}